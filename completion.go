@@ -0,0 +1,133 @@
+package lever
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// completionFuncName returns the name of the shell function a completion
+// script defines, derived from the app name
+func (f *Lever) completionFuncName() string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return r
+	}, f.appName)
+}
+
+// zshSpecEscaper escapes characters which have special meaning in the
+// description of a zsh _arguments spec
+var zshSpecEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"[", `\[`,
+	"]", `\]`,
+	":", `\:`,
+	"\n", " ",
+)
+
+// zshValueEscaper escapes characters which have special meaning in a value
+// listed in a zsh _arguments spec
+var zshValueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	":", `\:`,
+	" ", `\ `,
+	"(", `\(`,
+	")", `\)`,
+)
+
+// Completion returns a script which, when sourced by the given shell, completes
+// the names and aliases of every param on the command line of the application,
+// which is assumed to be run as its app name. For a param which takes a value
+// its Choices are completed if it has any, otherwise the value is completed as
+// a file name. The supported shells are "bash" and "zsh", any other gives an
+// error
+func (f *Lever) Completion(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return f.bashCompletion(), nil
+	case "zsh":
+		return f.zshCompletion(), nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q", shell)
+	}
+}
+
+func (f *Lever) bashCompletion() string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	fn := f.completionFuncName()
+	ps := f.sortedExpected()
+
+	fmt.Fprintf(buf, "# bash completion for %s\n\n", f.appName)
+	fmt.Fprintf(buf, "%s() {\n", fn)
+	fmt.Fprintln(buf, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(buf, "\tlocal prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	fmt.Fprintln(buf, "\tcase \"$prev\" in")
+	for _, p := range ps {
+		if p.Flag {
+			continue
+		}
+		fmt.Fprintf(buf, "\t%s)\n", strings.Join(p.allNames(), "|"))
+		if len(p.Choices) > 0 {
+			// compgen splits its word list on IFS, so choices are separated
+			// by newlines to allow them to contain spaces, and the matches
+			// are escaped so they're inserted as a single word each
+			choices := make([]string, len(p.Choices))
+			for i := range p.Choices {
+				choices[i] = shellQuote(p.Choices[i])
+			}
+			fmt.Fprintln(buf, "\t\tlocal IFS=$'\\n'")
+			fmt.Fprintf(buf,
+				"\t\tCOMPREPLY=($(compgen -W \"$(printf '%%s\\n' %s)\" -- \"$cur\"))\n",
+				strings.Join(choices, " "),
+			)
+			buf.WriteString("\t\t[ ${#COMPREPLY[@]} -gt 0 ] && COMPREPLY=($(printf '%q\\n' \"${COMPREPLY[@]}\"))\n")
+		} else {
+			fmt.Fprintln(buf, "\t\tCOMPREPLY=()")
+		}
+		fmt.Fprintln(buf, "\t\treturn 0")
+		fmt.Fprintln(buf, "\t\t;;")
+	}
+	fmt.Fprintln(buf, "\tesac")
+
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.allNames()...)
+	}
+	fmt.Fprintf(buf,
+		"\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n",
+		shellQuote(strings.Join(names, " ")),
+	)
+	fmt.Fprintln(buf, "}")
+	fmt.Fprintf(buf, "\ncomplete -o default -F %s %s\n", fn, shellQuote(f.appName))
+	return buf.String()
+}
+
+func (f *Lever) zshCompletion() string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+
+	fmt.Fprintf(buf, "#compdef %s\n\n", f.appName)
+	fmt.Fprintln(buf, "_arguments \\")
+	for _, p := range f.sortedExpected() {
+		desc := zshSpecEscaper.Replace(p.Description)
+		var action string
+		if !p.Flag && len(p.Choices) > 0 {
+			choices := make([]string, len(p.Choices))
+			for i := range p.Choices {
+				choices[i] = zshValueEscaper.Replace(p.Choices[i])
+			}
+			action = fmt.Sprintf(":%s:(%s)", trimDelim(p.Name), strings.Join(choices, " "))
+		} else if !p.Flag {
+			action = fmt.Sprintf(":%s:_files", trimDelim(p.Name))
+		}
+
+		for _, name := range p.allNames() {
+			spec := fmt.Sprintf("%s[%s]%s", name, desc, action)
+			fmt.Fprintf(buf, "\t%s \\\n", shellQuote(spec))
+		}
+	}
+	fmt.Fprintln(buf, "\t'*::arg:_files'")
+	return buf.String()
+}
@@ -0,0 +1,66 @@
+package lever
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCompletionLever() *Lever {
+	f := New("test-app", &Opts{DisallowConfigFile: true})
+	f.Add(Param{Name: "--foo", Aliases: []string{"-f"}, Description: "Foo: [it's a value]"})
+	f.Add(Param{Name: "--level", Choices: []string{"debug", "info level"}})
+	f.Add(Param{Name: "--verbose", Aliases: []string{"-v"}, Flag: true})
+	return f
+}
+
+func TestCompletionBash(t *T) {
+	s, err := testCompletionLever().Completion("bash")
+	require.Nil(t, err)
+	assert.Equal(t, `# bash completion for test-app
+
+_test_app() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	--foo|-f)
+		COMPREPLY=()
+		return 0
+		;;
+	--level)
+		local IFS=$'\n'
+		COMPREPLY=($(compgen -W "$(printf '%s\n' 'debug' 'info level')" -- "$cur"))
+		[ ${#COMPREPLY[@]} -gt 0 ] && COMPREPLY=($(printf '%q\n' "${COMPREPLY[@]}"))
+		return 0
+		;;
+	esac
+	COMPREPLY=($(compgen -W '--foo -f --help -help -h --level --verbose -v' -- "$cur"))
+}
+
+complete -o default -F _test_app 'test-app'
+`, s)
+}
+
+func TestCompletionZsh(t *T) {
+	s, err := testCompletionLever().Completion("zsh")
+	require.Nil(t, err)
+	assert.Equal(t, `#compdef test-app
+
+_arguments \
+	'--foo[Foo\: \[it'\''s a value\]]:foo:_files' \
+	'-f[Foo\: \[it'\''s a value\]]:foo:_files' \
+	'--help[Print this help message]' \
+	'-help[Print this help message]' \
+	'-h[Print this help message]' \
+	'--level[]:level:(debug info\ level)' \
+	'--verbose[]' \
+	'-v[]' \
+	'*::arg:_files'
+`, s)
+}
+
+func TestCompletionUnknownShell(t *T) {
+	_, err := testCompletionLever().Completion("fish")
+	assert.NotNil(t, err)
+}
@@ -21,7 +21,8 @@
 // In addition to the given Params, lever automatically adds in "--help" (to
 // print out a help page and exit), "--example" (to print out an example config
 // file and exit) and "--config" (to read in a config file and use values from
-// it). Exact behaviour can be tweaked through both Param and Opt fields.
+// it). Their names can be changed through Opts, and exact behaviour can be
+// tweaked through both Param and Opt fields.
 //
 // Values for the set params can be passed in through either the command line,
 // environment variables, a config file, or all three. The order of precedence
@@ -60,6 +61,22 @@
 //	./myapp --example > myapp.conf
 //	./myapp --config myapp.conf
 //
+// A config file of "-" will be read from stdin:
+//
+//	cat myapp.conf | ./myapp --config -
+//
+// --config can be given more than once, in which case every file is read, in
+// order, and a key's values in a later file replace those in earlier ones:
+//
+//	./myapp --config base.conf --config prod.conf
+//
+// Whitespace surrounding values in the config file is ignored. A space which
+// should be kept can be escaped with a backslash, and a literal backslash
+// written as two:
+//
+//	greeting: \ \ hello
+//	path: C:\\dir
+//
 // Retrieving values
 //
 // Any of the Param* methods can be used to retrieve values from within your
@@ -72,7 +89,7 @@
 // You use these methods regardless of the source of the values (command line,
 // environment, etc...). The names will be automatically translated from their
 // source naming to match what was used for the Name field in the original
-// Param.
+// Param. Any of the Param's Aliases may be used in place of its Name.
 //
 // Multi params
 //
@@ -96,18 +113,29 @@
 //	./myapp2 --multi bar --multi baz
 //	# multi == []string{"bar", "baz"}
 //
+// This can be changed per param using the MultiMerge field, so that the values
+// from all sources are combined instead.
+//
 package lever
 
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Param is a single configuration option which is specified by the user running
@@ -141,24 +169,267 @@ type Param struct {
 	// the default is no entries
 	DefaultMulti []string
 
+	// The name of an environment variable, for example "AWS_REGION", whose
+	// value should be used as this param's default if it is set. Default and
+	// DefaultMulti are only used if it isn't. Unlike the environment variable
+	// lever derives from the param's name this has the lowest precedence of
+	// any source
+	DefaultEnv string
+
 	// If the param is a flag (boolean switch, it doesn't expect a value), this
 	// must be set to true
 	Flag bool
 
+	// If set, once this param is seen on the command line (along with its
+	// value, if it isn't a flag) no further args are parsed, and all those
+	// following it are added to ParamRest() as they are, like with "--". This
+	// is useful for passing args on to another command
+	TerminatesParsing bool
+
+	// If set, the param is deprecated, and this describes what should be used
+	// instead, for example "use --new". It's shown in Help() and Example(), and
+	// a warning is included in Warnings() whenever the param is set, which
+	// names the variable if it was set by a fallback in EnvNames
+	Deprecated string
+
+	// If set, the param's values are replaced with "<redacted>" wherever lever
+	// displays them, for example in Dump(), Help() and the errors returned when
+	// parsing. They can still be retrieved as normal using the Param* methods.
+	// EnvScript() is the exception, since its purpose is to reproduce the
+	// configuration, and so are the errors returned by Validate, which are used
+	// as they are
+	Sensitive bool
+
+	// If set, the param's values are passed through this wherever lever
+	// displays them, and what it returns is shown instead, for example to only
+	// show the last few characters of a token. This takes precedence over
+	// Sensitive
+	Redact func(string) string
+
 	// If set to true this parameter will not appear in the example
 	// configuration file and will not be allowed to be set in it
 	DisallowInConfigFile bool
+
+	// If set to true this parameter will not be read from the environment, and
+	// any environment variable for it is ignored. DefaultEnv is still used
+	DisallowInEnv bool
+
+	// The minimum and maximum number of values the param may end up with once
+	// all sources have been merged, for params which are specified multiple
+	// times. 0 means no limit
+	MinValues int
+	MaxValues int
+
+	// How values for this param from different sources are combined. Defaults
+	// to MultiMergeReplace
+	MultiMerge MultiMerge
+
+	// If set, every value the param ends up with must be one of these, and
+	// they're listed in Help()
+	Choices []string
+
+	// If either is set, every value the param ends up with must be an integer
+	// no less than Min and no greater than Max, and the range is shown in
+	// Help(). nil means no bound
+	Min *int
+	Max *int
+
+	// If set, this is called with each value the param ends up with, and if
+	// it returns an error parsing fails with that error's message
+	Validate func(string) error
+
+	// If set, this overrides the normal precedence of sources for this param
+	// only, highest first. For example {SourceConfig, SourceCLI} makes the
+	// config file's value win over the command line's. Sources which aren't
+	// listed keep their normal order, below those which are
+	SourcePriority []Source
+
+	// The names of the environment variables this param's value is read from,
+	// in order of preference; the value of the first one which is set is used.
+	// If given, these replace the environment variable lever would otherwise
+	// derive from the param's name, so it should be included here if it's
+	// still wanted. This is useful when migrating from one name to another
+	EnvNames []string
+
+	// If set, the value of the param's environment variable is split on this
+	// to give multiple values, for example ":" to read "a:b:c" as "a", "b"
+	// and "c". Otherwise the environment variable gives a single value
+	EnvSeparator string
+
+	// If set on a flag, the flag is true whenever its environment variable is
+	// present, whatever its value, even if it's empty or "false"
+	EnvPresenceIsTrue bool
+
+	// If set, a value given on the command line which is a JSON array, for
+	// example '["a","b"]', gives each of its elements as a separate value.
+	// Any other value is used literally
+	AcceptJSONArray bool
+
+	// If set on a flag, each time the flag is given on the command line the
+	// integer param with this Name is incremented (or decremented) by one,
+	// starting from the value it would otherwise have. A flag with a single
+	// character name can be repeated, so that "-vvv" counts as "-v -v -v"
+	IncrementFor string
+	DecrementFor string
+
+	// If set, parsing will fail if this param doesn't end up with a value from
+	// any source. A default value counts, unless RequiredIgnoresDefault is set
+	// in Opts. A flag which is required must be explicitly set, either true or
+	// false (like "--flag=false" on the command line), and its default never
+	// counts
+	Required bool
+
+	// The kind of value the param holds. This is currently only used as a hint
+	// in Example(). Defaults to ParamTypeString
+	Type ParamType
 }
 
-// configName returns the name the param will take in the config file
-func (p *Param) configName() string {
-	return strings.TrimLeftFunc(p.Name, func(r rune) bool {
+// ParamConstraints summarizes the rules a param's values must follow for
+// parsing to succeed, see Constraints
+type ParamConstraints struct {
+	// Whether the param must end up with a value. See Param.Required
+	Required bool `json:"required,omitempty"`
+
+	// Whether a default value doesn't satisfy Required, see
+	// Opts.RequiredIgnoresDefault
+	RequiredIgnoresDefault bool `json:"required_ignores_default,omitempty"`
+
+	// The limits on the number of values, 0 meaning no limit. See
+	// Param.MinValues and Param.MaxValues
+	MinValues int `json:"min_values,omitempty"`
+	MaxValues int `json:"max_values,omitempty"`
+
+	// Whether the param's values must be booleans, which is the case for flags
+	Bool bool `json:"bool,omitempty"`
+
+	// The values the param's values must be one of. See Param.Choices
+	Choices []string `json:"choices,omitempty"`
+
+	// The bounds on the param's integer values, nil meaning no bound. See
+	// Param.Min and Param.Max
+	Min *int `json:"min,omitempty"`
+	Max *int `json:"max,omitempty"`
+}
+
+// ParamType describes the kind of value a param holds
+type ParamType int
+
+// All possible ParamType values
+const (
+	// Any string value
+	ParamTypeString ParamType = iota
+
+	// A value which can be parsed by time.ParseDuration, for example "30s"
+	ParamTypeDuration
+
+	// A size in bytes, optionally with a unit, for example "512KB" or "10MB"
+	ParamTypeSize
+)
+
+// exampleHint returns the comment shown in Example() describing the kind of
+// value the param expects, or "" if there isn't one
+func (p *Param) exampleHint() string {
+	switch p.Type {
+	case ParamTypeDuration:
+		return "# (duration, e.g. 30s, 1m)"
+	case ParamTypeSize:
+		return "# (size, e.g. 512KB, 10MB)"
+	default:
+		return ""
+	}
+}
+
+// redacted returns the given value of the param as it should be displayed, see
+// Sensitive and Redact. Empty values are left as they are
+func (p *Param) redacted(v string) string {
+	if v == "" {
+		return v
+	} else if p.Redact != nil {
+		return p.Redact(v)
+	} else if p.Sensitive {
+		return "<redacted>"
+	}
+	return v
+}
+
+// redactedMulti is like redacted, but for multiple values
+func (p *Param) redactedMulti(vs []string) []string {
+	if p.Redact == nil && !p.Sensitive {
+		return vs
+	}
+	rvs := make([]string, len(vs))
+	for i := range vs {
+		rvs[i] = p.redacted(vs[i])
+	}
+	return rvs
+}
+
+// exampleValue returns the given default value as it should be shown in
+// Example(). Durations are shown in their canonical form, for example "90s" is
+// shown as "1m30s"
+func (p *Param) exampleValue(v string) string {
+	if p.Type == ParamTypeDuration {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d.String()
+		}
+	}
+	return v
+}
+
+// MultiMerge describes how the values for a param from different sources (e.g.
+// command line and config file) are combined. Default values are never
+// combined with values from other sources, they are only used if no source
+// sets the param
+type MultiMerge int
+
+// All possible MultiMerge values
+const (
+	// Only the values from the source of highest precedence which set the
+	// param are used, all others are thrown away
+	MultiMergeReplace MultiMerge = iota
+
+	// Values from all sources are used, with those from sources of higher
+	// precedence coming after those of lower precedence. For example, values
+	// from the command line are appended to those from the config file
+	MultiMergeAppend
+
+	// Like MultiMergeAppend, but values from sources of higher precedence come
+	// before those of lower precedence
+	MultiMergePrepend
+)
+
+// trimDelim returns the given name with its leading delimiter, for example "--",
+// removed
+func trimDelim(name string) string {
+	return strings.TrimLeftFunc(name, func(r rune) bool {
 		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
 	})
 }
 
+// configName returns the name the param will take in the config file
+func (p *Param) configName() string {
+	return trimDelim(p.Name)
+}
+
+// allNames returns the Name and Aliases of the param
+func (p *Param) allNames() []string {
+	return append([]string{p.Name}, p.Aliases...)
+}
+
 func (p *Param) flagDefault() bool {
-	return p.Default == "true"
+	b, _ := parseFlag(p.Default)
+	return b
+}
+
+// parseFlag interprets a value given for a flag param, accepting the same
+// values as strconv.ParseBool (e.g. "1", "0", "true", "false"). The empty
+// string is interpreted as false. False is also returned, along with an error,
+// if the value isn't valid
+func parseFlag(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
 }
 
 // Implement sort.Interface
@@ -183,6 +454,16 @@ type Opts struct {
 	// If set lever will look in the given file (if it exists) for
 	DefaultConfigFile string
 
+	// If set and no config file is given, each of these paths is tried in
+	// order and the first which exists is used as the config file, before
+	// falling back to DefaultConfigFile. Environment variables in the paths,
+	// for example "$HOME/.config/myapp.conf", are expanded. Paths which don't
+	// exist, or which reference an unset variable, are skipped, but one which
+	// exists and can't be read is an error. If none are found and there's no
+	// DefaultConfigFile parsing fails, unless AllowMissingConfigFile is set.
+	// ParseWithConfigSearch replaces these paths with its own
+	ConfigSearchPaths []string
+
 	// Extra text which will be shown above the output of Help() when --help is
 	// set. A newline is not required
 	HelpHeader string
@@ -198,6 +479,216 @@ type Opts struct {
 	// If the config file is missing even though it is specified (either through
 	// default value or on the command line) do not error
 	AllowMissingConfigFile bool
+
+	// Where the output of --help and --example is written. Defaults to
+	// os.Stdout
+	Output io.Writer
+
+	// Where Parse writes the error it exits with, if any. Defaults to
+	// os.Stderr
+	ErrOutput io.Writer
+
+	// If set, when Parse exits because of an error it also writes the output
+	// of Help() after the error, to ErrOutput rather than Output. This has no
+	// effect when ErrorFormat is ErrorFormatJSON
+	HelpOnError bool
+
+	// External sources of param values, which are consulted in order for every
+	// param during parsing. The values from the first which has any for a param
+	// are used
+	Providers []Provider
+
+	// The source which values from Providers take precedence over, with all
+	// sources which normally have precedence over it also having precedence
+	// over Providers. For example SourceEnv places them between the command
+	// line and the environment. Defaults to SourceConfig
+	ProviderPrecedence Source
+
+	// If set, reading a config file which can be read by users other than its
+	// owner, i.e. whose group or others have read permission, will cause an
+	// error. This has no effect on Windows
+	RequireSecureConfigPerms bool
+
+	// If set, config values are read from this in addition to any config file,
+	// for example from a config embedded in the binary. It's read during the
+	// first parse, and what's read is used for all later ones. A key given in
+	// both this and a config file only takes its values from the config file.
+	// ConfigFormatAuto is treated as ConfigFormatNative
+	ConfigReader io.Reader
+
+	// Where the config file is read from if it is given as "-". Defaults to
+	// os.Stdin
+	Input io.Reader
+
+	// Lines in the config file which have no value after the delimiter (for
+	// example "foo:") are normally read in as an empty string value. If this is
+	// set they will be skipped instead, as if the line weren't there
+	SkipEmptyConfigValues bool
+
+	// Text which will be shown at the top of the output of Example() instead
+	// of the default "# <appName> configuration" line. It is used verbatim, so
+	// each line should be commented. A newline is not required. If set to "-"
+	// no header is shown at all
+	ExampleHeader string
+
+	// If set, keys in the config file which don't match any expected param
+	// will cause an error rather than being silently ignored
+	StrictConfig bool
+
+	// How errors are written to stderr by Parse before it exits. Defaults to
+	// ErrorFormatText. This has no effect on the errors returned by ParseArgs
+	ErrorFormat ErrorFormat
+
+	// If set, giving a value to a flag on the command line (for example
+	// "--flag=false") will cause an error, rather than the value being used
+	StrictFlags bool
+
+	// If set, the leading delimiter of each param's name and aliases (for
+	// example "--") is replaced with this when shown in Help(). This is only
+	// for display, the names which are actually accepted are unchanged
+	HelpNamePrefix string
+
+	// If set, command line arguments which look like flags (start with "-")
+	// but don't match any expected param will cause an error, rather than being
+	// added to ParamRest(). Arguments following "--" are never checked
+	StrictUnknown bool
+
+	// If set, environment variables which start with the app's prefix (for
+	// example "MYAPP_") but don't match any expected param will cause an
+	// error, rather than being silently ignored
+	StrictEnv bool
+
+	// Normally Add panics if it's called after parsing, since the new param
+	// won't have any values. If this is set it's allowed, and the param's
+	// values can be filled in using Resolve
+	AllowAddAfterParse bool
+
+	// If greater than zero, reading a config file larger than this many bytes
+	// will cause an error, whatever format it's in or ConfigDecoder reads it
+	MaxConfigBytes int64
+
+	// If set a --dump-env flag will be added, which prints the output of
+	// DumpEnv() to stdout and exits, the same as --example
+	DumpEnvFlag bool
+
+	// If set a --profile param will be added. When it's given, the config file
+	// for that profile is read in addition to the normal one, and its values
+	// take precedence. The profile's file is in the same directory, with the
+	// profile name added before the extension, for example
+	// "/etc/myapp/config.prod.conf" for the "prod" profile of
+	// "/etc/myapp/config.conf". The profile can itself be given in the normal
+	// config file. A key given in both files only takes its values from the
+	// profile's
+	ConfigProfiles bool
+
+	// If set a --dry-run flag will be added. Parsing is unaffected by it, but
+	// the application can check DryRun() and skip any side effects
+	DryRunFlag bool
+
+	// The separator shown between a param's name and each of its aliases in
+	// Help(). Defaults to ", "
+	AliasSeparator string
+
+	// If set, single character aliases (for example "-h") are shown before all
+	// other aliases in Help(). Otherwise aliases are shown in the order they
+	// were given
+	ShortAliasesFirst bool
+
+	// If set, a Required param must be given a value explicitly, through the
+	// command line, environment, or config file. Its default value will not
+	// satisfy the requirement
+	RequiredIgnoresDefault bool
+
+	// Lines in the config file which begin with any of these (ignoring leading
+	// whitespace) are comments, and are skipped. So is the rest of a line
+	// following one of these which is preceded by whitespace, for example
+	// "foo: bar ; comment", unless that whitespace is escaped. A value which
+	// starts with a prefix, like "color: #fff", is kept whole. "#" is always a
+	// comment prefix, whether or not it's included here, so that the output of
+	// Example() can be read back in
+	ConfigCommentPrefixes []string
+
+	// If set, this is called to get the name of the environment variable each
+	// param's value is read from, in place of DefaultEnvName. StrictEnv still
+	// uses the default prefix derived from the app's name
+	EnvNameFunc func(appName string, p *Param) string
+
+	// If set, the app's name is taken from the base name of os.Args[0] when New
+	// is called, rather than from the name given to New, so that a renamed
+	// binary reads its own environment variables and names itself in
+	// Example(). A ".exe" extension is removed. The given name is still used if
+	// os.Args[0] is empty
+	AppNameFromArgv0 bool
+
+	// If set, this is used in place of the app's name as the prefix of the
+	// environment variables lever derives, uppercased and with - replaced with
+	// _ the same way, for example "mysvc" to read "MYSVC_FOO" for "--foo"
+	EnvPrefix string
+
+	// The separator between the app's name and the param's name in the
+	// environment variables lever derives, for example "." to read
+	// "MYAPP.FOO_BAR" for "--foo-bar". Defaults to "_". This has no effect on
+	// DefaultEnvName or when EnvNameFunc is set, though StrictEnv uses it
+	// regardless
+	EnvPrefixSeparator string
+
+	// The names and aliases of the params lever adds for --help, --example and
+	// --config, for apps which use those names for something else or follow a
+	// different convention. Each name defaults to the one shown, and each set
+	// of aliases to the single dash form of the name ("-h" is also included for
+	// the default help name). An empty but non-nil slice means no aliases
+	HelpFlagName       string
+	HelpFlagAliases    []string
+	ExampleFlagName    string
+	ExampleFlagAliases []string
+	ConfigFlagName     string
+	ConfigFlagAliases  []string
+
+	// If set, this is used to render the DefaultMulti of each param in Help().
+	// By default the slice is shown using Go's formatting, for example
+	// "[a b c]"
+	MultiDefaultFormat func([]string) string
+
+	// The format config files are written in. Defaults to ConfigFormatAuto
+	ConfigFormat ConfigFormat
+
+	// If set, config files are read using each of these formats in turn, and
+	// the first which can be read and gives a value for at least one expected
+	// param is used, ConfigFormat being ignored. This is useful while migrating
+	// from one format to another. ConfigFormatUsed returns the format chosen
+	ConfigFormatFallback []ConfigFormat
+
+	// If set, this is used to read config files instead of lever's own
+	// parsing, and ConfigFormat is ignored
+	ConfigDecoder ConfigDecoder
+
+	// If set, a line of the form "include <path>" in a config file reads in the
+	// config file at that path, as if its lines were at that point. A relative
+	// path is relative to the directory of the file including it
+	AllowIncludes bool
+
+	// If set, each line in the config file may separate its key and value with
+	// either ":" or "=", whichever comes first in the line
+	FlexibleDelimiter bool
+
+	// If set, a key in the config file with no value may be followed by
+	// indented lines starting with "-", each of which gives one of its values:
+	//
+	//	buz:
+	//	  - a
+	//	  - b
+	//
+	// This is equivalent to giving the key once per value. The list ends at the
+	// first line which isn't indented
+	ConfigIndentedLists bool
+
+	// If set, params which are Deprecated are left out of Example(), so that
+	// new config files don't use them
+	HideDeprecatedInExample bool
+
+	// If set, lines in the config file which can't be parsed are skipped
+	// rather than causing an error. Each one skipped is included in Warnings()
+	LenientConfig bool
 }
 
 // Lever is an instance of the paramater parser, which can have expected
@@ -209,6 +700,66 @@ type Lever struct {
 	expectedFull map[string]*Param // expected, plus another key for each alias
 	found        map[string][]string
 	remaining    []string
+
+	// the Names of the params lever adds itself
+	helpFlag, exampleFlag, configFlag string
+
+	// the index in remaining of the first arg which followed "--"
+	restSplit int
+
+	// values from the config file whose keys didn't match any expected param
+	unknownConfig map[string][]string
+
+	// set once parsing has completed, after which no more params may be added
+	frozen bool
+
+	// the found values from the parse before the most recent one, if any
+	previous map[string][]string
+
+	// the source each value in found came from
+	foundSource map[string]Source
+
+	// values given through Override
+	overrides map[string][]string
+
+	// values given through Seed
+	seeded map[string][]string
+
+	// extra environment variables to read each param from, see MapEnv
+	mappedEnv map[string][]string
+
+	// params added through AddComputed, in the order they were added
+	computed []computedParam
+
+	// the values read from ConfigReader, see readConfigReader
+	readerKeyed map[string][]string
+
+	// set for the duration of ParseRequireConfig(true)
+	requireConfig bool
+
+	// set while ParseFlagsOnly reads the command line
+	flagsOnly bool
+
+	// paths to search for a config file in, see ConfigSearchPaths
+	configSearch []string
+
+	// the config file read during the most recent parse, if any, and the
+	// format it was read in
+	configPath       string
+	configFileFormat ConfigFormat
+
+	// problems which didn't cause the most recent parse to fail
+	warnings []string
+
+	// the raw inputs to the most recent parse, kept for Resolve
+	args, environ []string
+	configKeyed   map[string][]string
+
+	// the values from Providers during the most recent parse, and the params
+	// which they've been looked up for, kept so that Resolve doesn't have to
+	// look them all up again
+	provided        map[string][]string
+	providerChecked map[string]bool
 }
 
 // New instantiates a new Lever with the given appName and Opts (or nil to just
@@ -219,35 +770,67 @@ func New(appName string, o *Opts) *Lever {
 	if o == nil {
 		o = &Opts{}
 	}
+	if o.AppNameFromArgv0 {
+		appName = argv0Name(appName)
+	}
 
 	f := Lever{
 		appName:      appName,
 		o:            o,
 		expected:     map[string]*Param{},
 		expectedFull: map[string]*Param{},
+		helpFlag:     orDefault(o.HelpFlagName, "--help"),
+		exampleFlag:  orDefault(o.ExampleFlagName, "--example"),
+		configFlag:   orDefault(o.ConfigFlagName, "--config"),
+		configSearch: o.ConfigSearchPaths,
 	}
 
 	if !o.DisallowConfigFile {
 		f.Add(Param{
-			Name:                 "--config",
-			Aliases:              []string{"-config"},
+			Name:                 f.configFlag,
+			Aliases:              builtinAliases(o.ConfigFlagAliases, f.configFlag, "--config", "-config"),
 			Description:          "Configuration file to load",
 			Default:              o.DefaultConfigFile,
 			DisallowInConfigFile: true,
 		})
 
 		f.Add(Param{
-			Name:                 "--example",
-			Aliases:              []string{"-example"},
+			Name:                 f.exampleFlag,
+			Aliases:              builtinAliases(o.ExampleFlagAliases, f.exampleFlag, "--example", "-example"),
 			Description:          "Dump an example configuration, filled with default values, to stdout",
 			Flag:                 true,
 			DisallowInConfigFile: true,
 		})
 	}
 
+	if o.ConfigProfiles && !o.DisallowConfigFile {
+		f.Add(Param{
+			Name:        "--profile",
+			Description: "Name of a profile whose config file is layered on top of the one given by --config",
+		})
+	}
+
+	if o.DryRunFlag {
+		f.Add(Param{
+			Name:                 "--dry-run",
+			Description:          "Resolve the configuration but don't act on it",
+			Flag:                 true,
+			DisallowInConfigFile: true,
+		})
+	}
+
+	if o.DumpEnvFlag {
+		f.Add(Param{
+			Name:                 dumpEnvFlag,
+			Description:          "Print the environment variables which are read, and their current values, to stdout",
+			Flag:                 true,
+			DisallowInConfigFile: true,
+		})
+	}
+
 	f.Add(Param{
-		Name:                 "--help",
-		Aliases:              []string{"-help", "-h"},
+		Name:                 f.helpFlag,
+		Aliases:              builtinAliases(o.HelpFlagAliases, f.helpFlag, "--help", "-help", "-h"),
 		Description:          "Print this help message",
 		Flag:                 true,
 		DisallowInConfigFile: true,
@@ -256,8 +839,50 @@ func New(appName string, o *Opts) *Lever {
 	return &f
 }
 
-// Add the given parameter as an expected parameter for the process
+// argv0Name returns the base name of the running executable, as given by
+// os.Args[0], or def if there isn't one
+func argv0Name(def string) string {
+	if len(os.Args) == 0 || os.Args[0] == "" {
+		return def
+	}
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return def
+	}
+	return name
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// builtinAliases returns the aliases of a builtin param named name, whose
+// default name is def. Unless given explicitly they're defAliases when the name
+// is the default, otherwise the single dash form of the custom name
+func builtinAliases(aliases []string, name, def string, defAliases ...string) []string {
+	if aliases != nil {
+		return aliases
+	}
+	if name == def {
+		return defAliases
+	}
+	if alias := "-" + trimDelim(name); alias != name {
+		return []string{alias}
+	}
+	return nil
+}
+
+// Add the given parameter as an expected parameter for the process. Add must be
+// called before Parse, since params added afterwards would have no effect on
+// the already parsed values, and will panic if it isn't (unless
+// AllowAddAfterParse is set in Opts)
 func (f *Lever) Add(p Param) {
+	if f.frozen && !f.o.AllowAddAfterParse {
+		panic(fmt.Sprintf("lever: Add(%q) called after Parse", p.Name))
+	}
 	f.expected[p.Name] = &p
 	f.expectedFull[p.Name] = &p
 	for _, alias := range p.Aliases {
@@ -265,6 +890,49 @@ func (f *Lever) Add(p Param) {
 	}
 }
 
+// checkName returns an error if the given param name or alias isn't valid, or
+// is already in use by an expected param
+func (f *Lever) checkName(name string) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	} else if strings.ContainsAny(name, ":=") {
+		return fmt.Errorf("%q must not contain : or =", name)
+	} else if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("%q must not contain whitespace", name)
+	} else if p, ok := f.expectedFull[name]; ok {
+		return fmt.Errorf("%q is already used by %s", name, p.Name)
+	}
+	return nil
+}
+
+// AddAll adds each of the given params, in order, as with Add. Unlike Add, each
+// param's Name and Aliases are checked first, and params with empty or invalid
+// names, or names which are already in use, are not added. A MultiError of
+// *ParamErrors is returned describing every param which wasn't added
+func (f *Lever) AddAll(ps []Param) error {
+	var errs MultiError
+	for i, p := range ps {
+		err := f.checkName(p.Name)
+		for _, alias := range p.Aliases {
+			if err != nil {
+				break
+			}
+			err = f.checkName(alias)
+		}
+
+		if err != nil {
+			name := p.Name
+			if name == "" {
+				name = fmt.Sprintf("params[%d]", i)
+			}
+			errs = append(errs, &ParamError{Param: name, Message: err.Error()})
+			continue
+		}
+		f.Add(p)
+	}
+	return errs.err()
+}
+
 func (f *Lever) sortedExpected() params {
 	ps := make(params, 0, len(f.expected))
 	for _, p := range f.expected {
@@ -274,6 +942,101 @@ func (f *Lever) sortedExpected() params {
 	return ps
 }
 
+// dumpEnvFlag is the Name of the flag added by DumpEnvFlag
+const dumpEnvFlag = "--dump-env"
+
+// isBuiltin returns whether the param is one of those lever adds itself, such as
+// --help
+func (f *Lever) isBuiltin(p *Param) bool {
+	switch p.Name {
+	case f.helpFlag:
+		return true
+	case f.exampleFlag, f.configFlag:
+		return !f.o.DisallowConfigFile
+	case "--profile":
+		return f.o.ConfigProfiles && !f.o.DisallowConfigFile
+	case "--dry-run":
+		return f.o.DryRunFlag
+	case dumpEnvFlag:
+		return f.o.DumpEnvFlag
+	}
+	return false
+}
+
+// Params returns a copy of every param which has been added, sorted by Name. If
+// includeBuiltin is false the params lever adds itself, such as --help and
+// --config, are left out
+func (f *Lever) Params(includeBuiltin bool) []Param {
+	ps := make([]Param, 0, len(f.expected))
+	for _, p := range f.sortedExpected() {
+		if includeBuiltin || !f.isBuiltin(p) {
+			ps = append(ps, *p)
+		}
+	}
+	return ps
+}
+
+// ParamNames is like Params, but returns only the Name of each param
+func (f *Lever) ParamNames(includeBuiltin bool) []string {
+	ps := f.Params(includeBuiltin)
+	names := make([]string, len(ps))
+	for i := range ps {
+		names[i] = ps[i].Name
+	}
+	return names
+}
+
+// CheckDocumented returns the Names of the params which have no Description,
+// sorted, or nil if every param has one. This can be used in tests to make sure
+// Help() is complete
+func (f *Lever) CheckDocumented() []string {
+	var names []string
+	for _, p := range f.sortedExpected() {
+		if strings.TrimSpace(p.Description) == "" {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// helpName returns the given param name or alias as it should be displayed in
+// Help()
+func (f *Lever) helpName(name string) string {
+	if f.o.HelpNamePrefix == "" {
+		return name
+	}
+	return f.o.HelpNamePrefix + trimDelim(name)
+}
+
+// helpAliases returns the param's aliases in the order they should be displayed
+// in Help()
+func (f *Lever) helpAliases(p *Param) []string {
+	if !f.o.ShortAliasesFirst {
+		return p.Aliases
+	}
+	aliases := append([]string{}, p.Aliases...)
+	sort.SliceStable(aliases, func(i, j int) bool {
+		return utf8.RuneCountInString(trimDelim(aliases[i])) == 1 &&
+			utf8.RuneCountInString(trimDelim(aliases[j])) != 1
+	})
+	return aliases
+}
+
+// helpNames returns the param's Name followed by its aliases, as they should be
+// displayed in Help(). Names which are the same once HelpNamePrefix has been
+// applied, for example "--help" and "-help", are only shown once
+func (f *Lever) helpNames(p *Param) []string {
+	names := []string{f.helpName(p.Name)}
+	seen := map[string]bool{names[0]: true}
+	for _, alias := range f.helpAliases(p) {
+		if name := f.helpName(alias); !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
+
 // Help returns a string representing exactly what would be written to stdout if
 // --help is set
 func (f *Lever) Help() string {
@@ -285,14 +1048,19 @@ func (f *Lever) Help() string {
 	}
 
 	fmt.Fprintln(buf, "")
+	sep := f.o.AliasSeparator
+	if sep == "" {
+		sep = ", "
+	}
+
 	for _, p := range ps {
-		fmt.Fprintf(buf, "\t%s", p.Name)
-		for _, alias := range p.Aliases {
-			fmt.Fprintf(buf, ", %s", alias)
-		}
+		fmt.Fprintf(buf, "\t%s", strings.Join(f.helpNames(p), sep))
 		if p.Flag {
 			fmt.Fprintf(buf, " (flag)")
 		}
+		if p.Deprecated != "" {
+			fmt.Fprintf(buf, " (deprecated: %s)", p.Deprecated)
+		}
 		fmt.Fprintf(buf, "\n")
 
 		var multiline bool
@@ -301,11 +1069,24 @@ func (f *Lever) Help() string {
 			multiline = true
 		}
 
-		if p.DefaultMulti != nil {
-			fmt.Fprintf(buf, "\t\tDefault: %v\n", p.DefaultMulti)
+		if len(p.Choices) > 0 {
+			fmt.Fprintf(buf, "\t\tChoices: %s\n", strings.Join(p.Choices, ", "))
+			multiline = true
+		}
+
+		if r := p.rangeHelp(); r != "" {
+			fmt.Fprintf(buf, "\t\tRange: %s\n", r)
+			multiline = true
+		}
+
+		if p.DefaultMulti != nil && f.o.MultiDefaultFormat != nil {
+			fmt.Fprintf(buf, "\t\tDefault: %s\n", f.o.MultiDefaultFormat(p.redactedMulti(p.DefaultMulti)))
+			multiline = true
+		} else if p.DefaultMulti != nil {
+			fmt.Fprintf(buf, "\t\tDefault: %v\n", p.redactedMulti(p.DefaultMulti))
 			multiline = true
 		} else if p.Default != "" {
-			fmt.Fprintf(buf, "\t\tDefault: %s\n", p.Default)
+			fmt.Fprintf(buf, "\t\tDefault: %s\n", p.redacted(p.Default))
 			multiline = true
 		}
 
@@ -328,15 +1109,25 @@ func (f *Lever) Example() string {
 	buf := bytes.NewBuffer(make([]byte, 0, 1024))
 	ps := f.sortedExpected()
 
-	fmt.Fprintf(buf, "# %s configuration\n\n", f.appName)
+	if f.o.ExampleHeader == "" {
+		fmt.Fprintf(buf, "# %s configuration\n\n", f.appName)
+	} else if f.o.ExampleHeader != "-" {
+		fmt.Fprintf(buf, "%s\n\n", strings.TrimRight(f.o.ExampleHeader, "\n"))
+	}
 	for _, p := range ps {
-		if p.DisallowInConfigFile {
+		if p.DisallowInConfigFile || (p.Deprecated != "" && f.o.HideDeprecatedInExample) {
 			continue
 		}
 
 		if p.Description != "" {
 			fmt.Fprintf(buf, "# %s\n", p.Description)
 		}
+		if p.Deprecated != "" {
+			fmt.Fprintf(buf, "# deprecated: %s\n", p.Deprecated)
+		}
+		if hint := p.exampleHint(); hint != "" && !p.Flag {
+			fmt.Fprintf(buf, "%s\n", hint)
+		}
 
 		name := p.configName()
 		if p.Flag {
@@ -345,37 +1136,179 @@ func (f *Lever) Example() string {
 			} else {
 				fmt.Fprintf(buf, "%s: false\n", name)
 			}
+		} else if p.Required && p.Default == "" && p.DefaultMulti == nil {
+			// There's no sensible value to fill in, so leave it commented out
+			// so the config won't be accepted until the user fills it in
+			fmt.Fprintf(buf, "# required\n# %s:\n", name)
 		} else if len(p.DefaultMulti) > 0 {
 			for _, d := range p.DefaultMulti {
-				fmt.Fprintf(buf, "%s: %s\n", name, d)
+				fmt.Fprintf(buf, "%s: %s\n", name, p.redacted(p.exampleValue(d)))
 			}
 		} else if p.DefaultMulti != nil {
 			fmt.Fprintf(buf, "# %s:\n", name)
 		} else {
-			fmt.Fprintf(buf, "%s: %s\n", name, p.Default)
+			fmt.Fprintf(buf, "%s: %s\n", name, p.redacted(p.exampleValue(p.Default)))
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+
+	return buf.String()
+}
+
+// escapeConfigValue is the inverse of unescapeConfigValue. Spaces which would
+// otherwise begin a comment with one of the given prefixes are escaped too. A
+// prefix at the very start of the value needs no escaping, since a comment can
+// only begin once the value has
+func escapeConfigValue(s string, commentPrefixes []string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	for _, prefix := range commentPrefixes {
+		s = strings.Replace(s, " "+prefix, `\ `+prefix, -1)
+	}
+	trimmed := strings.TrimLeft(s, " ")
+	s = strings.Repeat(`\ `, len(s)-len(trimmed)) + trimmed
+	trimmed = strings.TrimRight(s, " ")
+	return trimmed + strings.Repeat(`\ `, len(s)-len(trimmed))
+}
+
+// Dump writes the values resolved by the most recent parse to the given writer,
+// in the same format as Example(), so that they can be read back in as a config
+// file. Params which have no values, or which can't be set in the config file,
+// are left out. The values of Sensitive params, or params with Redact set, are
+// redacted, and so won't read back in as they were. Parse must have been called
+// already
+func (f *Lever) Dump(w io.Writer) error {
+	if !f.frozen {
+		return errors.New("lever has not been parsed")
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	for _, p := range f.sortedExpected() {
+		vals := f.found[p.Name]
+		if p.DisallowInConfigFile || (!p.Flag && len(vals) == 0) {
+			continue
+		}
+
+		if p.Description != "" {
+			fmt.Fprintf(buf, "# %s\n", p.Description)
+		}
+
+		name := p.configName()
+		if p.Flag {
+			fmt.Fprintf(buf, "%s: %t\n", name, f.ParamFlag(p.Name))
+		} else {
+			for _, v := range vals {
+				fmt.Fprintf(buf, "%s: %s\n", name, escapeConfigValue(p.redacted(v), f.commentPrefixes()))
+			}
 		}
 		fmt.Fprintf(buf, "\n")
 	}
 
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// DumpEnv returns a string representing exactly what would be written to stdout
+// if --dump-env is set, using the given environment (each element of the form
+// key=val). Each environment variable which lever would read is listed, sorted
+// by param Name, along with its value in the environment if it is set. The
+// flags which print something and exit, like --help, are not included
+func (f *Lever) DumpEnv(environ []string) string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	for _, p := range f.sortedExpected() {
+		if f.isExitFlag(p) {
+			continue
+		}
+		for _, name := range f.envNames(p) {
+			if v, ok := lookupEnv(environ, name); ok {
+				fmt.Fprintf(buf, "%s=%s\n", name, p.redacted(v))
+			} else {
+				fmt.Fprintf(buf, "%s (unset)\n", name)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// isExitFlag returns whether the param is one of the flags lever adds which
+// print something and exit
+func (f *Lever) isExitFlag(p *Param) bool {
+	isExit := p.Name == f.helpFlag || p.Name == f.exampleFlag || p.Name == dumpEnvFlag
+	return isExit && f.isBuiltin(p)
+}
+
+// shellQuote returns the string quoted such that a POSIX shell will interpret
+// it literally
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// EnvScript returns a shell script which exports the resolved value of every
+// param which was set, sorted by param Name, using the environment variable
+// lever would read that param from. Sourcing the script and running with no
+// arguments or config file will reproduce the current configuration. Flags are
+// written out as true or false, except for false EnvPresenceIsTrue flags, which
+// are left out since exporting them would make them true. Params with multiple
+// values are joined using their EnvSeparator, or if they don't have one are
+// left out, as are params with DisallowInEnv set and the flags which print
+// something and exit, like --help. Values are not redacted, even for Sensitive
+// params, so the script should be treated as a secret. Parse must have been
+// called already
+func (f *Lever) EnvScript() string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	for _, p := range f.sortedExpected() {
+		vals, ok := f.found[p.Name]
+		if !ok || f.isExitFlag(p) || p.DisallowInEnv {
+			continue
+		} else if len(vals) > 1 && p.EnvSeparator == "" {
+			continue
+		}
+
+		var v string
+		if p.Flag {
+			// Any value would make a presence flag true, so a false one can
+			// only be reproduced by leaving its variable unset
+			if p.EnvPresenceIsTrue && !f.ParamFlag(p.Name) {
+				continue
+			}
+			v = strconv.FormatBool(f.ParamFlag(p.Name))
+		} else {
+			v = strings.Join(vals, p.EnvSeparator)
+		}
+		name := f.envNames(p)[0]
+		fmt.Fprintf(buf, "export %s=%s\n", name, shellQuote(v))
+	}
 	return buf.String()
 }
 
 // readCLI takes in the given args, presumably from the cli (minus the call
-// string) and parses them in the context of the expected parameters
-func (f *Lever) readCLI(args []string) (map[string][]string, []string) {
+// string) and parses them in the context of the expected parameters. An error
+// is returned if any of the args were used incorrectly, but all args are still
+// processed
+func (f *Lever) readCLI(args []string) (map[string][]string, []string, error) {
+	found, unknown, verbatim, err := f.readCLIRest(args)
+	return found, append(unknown, verbatim...), err
+}
+
+// readCLIRest is like readCLI, but returns the args which weren't expected
+// separately from those following a "--" arg or a TerminatesParsing param
+func (f *Lever) readCLIRest(
+	args []string,
+) (
+	map[string][]string, []string, []string, error,
+) {
 	var arg string
+	var errs MultiError
 	found := map[string][]string{}
-	remaining := make([]string, 0, len(args))
+	unknown := make([]string, 0, len(args))
 
 	for {
 		if len(args) == 0 {
-			return found, remaining
+			return found, unknown, []string{}, errs.err()
 		}
 
 		arg, args = args[0], args[1:]
 		if arg == "--" {
-			remaining = append(remaining, args...)
-			return found, remaining
+			return found, unknown, append([]string{}, args...), errs.err()
 		}
 
 		argParts := strings.SplitN(arg, "=", 2)
@@ -389,197 +1322,1994 @@ func (f *Lever) readCLI(args []string) (map[string][]string, []string) {
 		}
 
 		p, ok := f.expectedFull[argName]
-		if !ok {
-			remaining = append(remaining, arg)
+		if ok && !p.Flag && f.flagsOnly {
+			// Leave the param and its value for a later parse
+			unknown = append(unknown, arg)
+			if !argValOk && len(args) > 0 {
+				unknown, args = append(unknown, args[0]), args[1:]
+			}
+			continue
+		} else if !ok {
+			if p, n := f.repeatedAdjustFlag(arg); p != nil {
+				for i := 0; i < n; i++ {
+					found[p.Name] = append(found[p.Name], "true")
+				}
+				continue
+			}
+			if expanded := f.shortFlagCluster(arg); expanded != nil {
+				args = append(expanded, args...)
+				continue
+			}
+			if f.o.StrictUnknown && len(argName) > 1 && strings.HasPrefix(argName, "-") {
+				errs = append(errs, &ParamError{
+					Param:   argName,
+					Source:  SourceCLI,
+					Message: "unknown flag",
+				})
+			}
+			unknown = append(unknown, arg)
 			continue
 		}
 
 		if p.Flag {
-			if p.flagDefault() {
+			if argValOk && f.o.StrictFlags {
+				errs = append(errs, &ParamError{
+					Param:   p.Name,
+					Source:  SourceCLI,
+					Message: fmt.Sprintf("flag does not take a value, but was given %q", arg),
+				})
+			}
+			if argValOk && !f.o.StrictFlags {
+				// A value which can't be interpreted is kept as it is, like
+				// one from the environment or config file, see ParamFlag
+				if b, err := parseFlag(argVal); err == nil {
+					argVal = strconv.FormatBool(b)
+				}
+				found[p.Name] = append(found[p.Name], argVal)
+			} else if p.flagDefault() {
 				found[p.Name] = append(found[p.Name], "false")
 			} else {
 				found[p.Name] = append(found[p.Name], "true")
 			}
-			continue
-		}
+		} else {
+			if !argValOk && len(args) > 0 {
+				argVal, args = args[0], args[1:]
+			}
 
-		if !argValOk && len(args) > 0 {
-			argVal, args = args[0], args[1:]
+			if vals, ok := jsonArrayValues(argVal); ok && p.AcceptJSONArray {
+				found[p.Name] = append(found[p.Name], vals...)
+			} else {
+				found[p.Name] = append(found[p.Name], argVal)
+			}
 		}
 
-		found[p.Name] = append(found[p.Name], argVal)
+		if p.TerminatesParsing {
+			return found, unknown, append([]string{}, args...), errs.err()
+		}
 	}
 }
 
-// readConfig reads any expected params out of the given reader and returns the
-// ones found, or an error if something goes wrong
-func (f *Lever) readConfig(r io.Reader) (map[string][]string, error) {
-	found := map[string][]string{}
-	rr := bufio.NewReader(r)
-
-	for {
-		line, err := rr.ReadString('\n')
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" || line[0] == '#' {
+// repeatedAdjustFlag checks if the given arg is a single character name of an
+// IncrementFor or DecrementFor flag with its character repeated, for example
+// "-vvv" for "-v". If so that flag's param is returned along with the number of
+// times it was repeated
+func (f *Lever) repeatedAdjustFlag(arg string) (*Param, int) {
+	for _, p := range f.expected {
+		if !p.Flag || (p.IncrementFor == "" && p.DecrementFor == "") {
 			continue
 		}
-
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("could not parse line: %q", line)
-		}
-
-		name, val := parts[0], strings.TrimSpace(parts[1])
-		for n := range f.expected {
-			if !strings.HasSuffix(n, name) {
+		for _, name := range p.allNames() {
+			c := trimDelim(name)
+			if utf8.RuneCountInString(c) != 1 {
 				continue
 			}
-			found[n] = append(found[n], val)
+			prefix := name[:len(name)-len(c)]
+			if !strings.HasPrefix(arg, prefix) {
+				continue
+			}
+			rest := arg[len(prefix):]
+			if len(rest) >= 2*len(c) && rest == strings.Repeat(c, len(rest)/len(c)) {
+				return p, len(rest) / len(c)
+			}
 		}
 	}
+	return nil, 0
+}
 
+// shortFlagCluster returns the given arg expanded into the separate single
+// character aliases it's made of, for example "-abc" into "-a", "-b" and "-c",
+// so long as each is the alias of a flag. The last may instead be the alias of
+// a param which takes a value, in which case the value is the following arg,
+// like with getopt. nil is returned if the arg can't be expanded
+func (f *Lever) shortFlagCluster(arg string) []string {
+	if len(arg) < 3 || arg[0] != '-' || arg[1] == '-' || strings.Contains(arg, "=") {
+		return nil
+	}
+
+	chars := []rune(arg[1:])
+	expanded := make([]string, len(chars))
+	for i, c := range chars {
+		alias := "-" + string(c)
+		p, ok := f.expectedFull[alias]
+		if !ok || (!p.Flag && i != len(chars)-1) {
+			return nil
+		}
+		expanded[i] = alias
+	}
+	return expanded
+}
+
+// applyAdjustments adjusts the values of the params targeted by IncrementFor
+// and DecrementFor flags, according to how many times those flags were given on
+// the command line. Adjusted values are counted as coming from the command line
+func (f *Lever) applyAdjustments(
+	found, foundCLI map[string][]string, foundSource map[string]Source,
+) {
+	deltas := map[string]int{}
+	for n, p := range f.expected {
+		if p.IncrementFor != "" {
+			deltas[p.IncrementFor] += len(foundCLI[n])
+		}
+		if p.DecrementFor != "" {
+			deltas[p.DecrementFor] -= len(foundCLI[n])
+		}
+	}
+
+	for n, delta := range deltas {
+		if delta == 0 {
+			continue
+		}
+		var base int
+		if vs := found[n]; len(vs) > 0 {
+			base, _ = strconv.Atoi(vs[0])
+		}
+		found[n] = []string{strconv.Itoa(base + delta)}
+		foundSource[n] = SourceCLI
+	}
+}
+
+// readConfig reads any expected params out of the given reader and returns the
+// ones found, or an error if something goes wrong
+func (f *Lever) readConfig(r io.Reader) (map[string][]string, error) {
+	keyed, _, err := f.readConfigKeys(r, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	found, _ := f.matchConfigKeys(keyed)
 	return found, nil
 }
 
+// maxIncludeDepth is how deeply config files may include each other, see
+// AllowIncludes, which prevents include cycles from recursing forever
+const maxIncludeDepth = 10
+
+// readConfigKeys reads all key/value pairs out of the given reader, keyed by
+// the key as it was written in the config file, or returns an error if
+// something goes wrong. path is where the reader was opened from, if it was a
+// file, and depth is how many files included this one
+func (f *Lever) readConfigKeys(
+	r io.Reader, path string, depth int,
+) (
+	map[string][]string, []string, error,
+) {
+	// Allow reading one byte more than the max, so that it can be told if the
+	// limit was exceeded
+	var lr *io.LimitedReader
+	if f.o.MaxConfigBytes > 0 {
+		lr = &io.LimitedReader{R: r, N: f.o.MaxConfigBytes + 1}
+		r = lr
+	}
+
+	keyed := map[string][]string{}
+	var warnings []string
+	rr := bufio.NewReader(r)
+
+	// the key whose indented list items are currently being read, see
+	// ConfigIndentedLists, and whether its line's empty value was kept
+	var listKey string
+	var listKeyEmpty bool
+
+	for {
+		line, err := rr.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		// Trailing whitespace is left for unescapeConfigValue to deal with,
+		// since some of it may be escaped
+		indented := line[0] == ' ' || line[0] == '\t'
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if strings.TrimSpace(line) == "" || f.isConfigComment(line) {
+			continue
+		}
+
+		if f.o.AllowIncludes && strings.HasPrefix(line, "include ") {
+			listKey = ""
+			incPath := strings.TrimSpace(strings.TrimPrefix(line, "include "))
+			incKeyed, incWarnings, err := f.readInclude(incPath, path, depth)
+			if err != nil {
+				return nil, nil, err
+			}
+			for k, vals := range incKeyed {
+				keyed[k] = append(keyed[k], vals...)
+			}
+			warnings = append(warnings, incWarnings...)
+			continue
+		}
+
+		if listKey != "" && indented && strings.HasPrefix(line, "-") {
+			if listKeyEmpty {
+				keyed[listKey] = keyed[listKey][:len(keyed[listKey])-1]
+				listKeyEmpty = false
+			}
+			val := unescapeConfigValue(f.stripConfigComment(line[1:]))
+			keyed[listKey] = append(keyed[listKey], val)
+			continue
+		}
+		listKey = ""
+
+		delim := ":"
+		if i := strings.IndexAny(line, ":="); f.o.FlexibleDelimiter && i >= 0 {
+			delim = line[i : i+1]
+		}
+		parts := strings.SplitN(line, delim, 2)
+		if len(parts) != 2 {
+			err := fmt.Sprintf("could not parse line: %q", strings.TrimSpace(line))
+			if !f.o.LenientConfig {
+				return nil, nil, errors.New(err)
+			}
+			warnings = append(warnings, err)
+			continue
+		}
+
+		name := strings.TrimRightFunc(parts[0], unicode.IsSpace)
+		val := unescapeConfigValue(f.stripConfigComment(parts[1]))
+		if val == "" && f.o.ConfigIndentedLists {
+			listKey = name
+			listKeyEmpty = !f.o.SkipEmptyConfigValues
+		}
+		if val == "" && f.o.SkipEmptyConfigValues {
+			continue
+		}
+
+		keyed[name] = append(keyed[name], val)
+	}
+
+	if lr != nil && lr.N == 0 {
+		return nil, nil, fmt.Errorf("exceeds maximum size of %d bytes", f.o.MaxConfigBytes)
+	}
+
+	return keyed, warnings, nil
+}
+
+// configFormat returns the format the config file at the given path should be
+// read in
+func (f *Lever) configFormat(path string) ConfigFormat {
+	if f.o.ConfigFormat != ConfigFormatAuto {
+		return f.o.ConfigFormat
+	} else if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ConfigFormatJSON
+	}
+	return ConfigFormatNative
+}
+
+// decodeConfigKeys is like readConfigKeys, but reads the config file in the
+// format appropriate for the given path
+func (f *Lever) decodeConfigKeys(
+	r io.Reader, path string,
+) (
+	map[string][]string, []string, error,
+) {
+	keyed, _, warnings, err := f.decodeConfigFormat(r, path)
+	return keyed, warnings, err
+}
+
+// decodeConfigFormat is like decodeConfigKeys, but also returns the format the
+// config file was read in, or ConfigFormatAuto if the ConfigDecoder was used.
+// If ConfigFormatFallback is set each of its formats is tried in turn
+func (f *Lever) decodeConfigFormat(
+	r io.Reader, path string,
+) (
+	map[string][]string, ConfigFormat, []string, error,
+) {
+	if f.o.ConfigDecoder != nil {
+		keyed, err := f.readConfigDecoder(r)
+		return keyed, ConfigFormatAuto, nil, err
+	} else if len(f.o.ConfigFormatFallback) == 0 {
+		format := f.configFormat(path)
+		keyed, warnings, err := f.readConfigFormat(r, path, format)
+		return keyed, format, warnings, err
+	}
+
+	// The file has to be held in memory to be read more than once, so the
+	// limit is checked up front rather than by each format
+	if f.o.MaxConfigBytes > 0 {
+		r = io.LimitReader(r, f.o.MaxConfigBytes+1)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, ConfigFormatAuto, nil, err
+	} else if f.o.MaxConfigBytes > 0 && int64(len(b)) > f.o.MaxConfigBytes {
+		return nil, ConfigFormatAuto, nil, fmt.Errorf(
+			"exceeds maximum size of %d bytes", f.o.MaxConfigBytes,
+		)
+	}
+
+	// If no format gives any expected params the first which could be read at
+	// all is used, so that the unknown keys are still reported
+	var firstKeyed map[string][]string
+	var firstFormat ConfigFormat
+	var firstWarnings []string
+	var firstErr error
+	for _, format := range f.o.ConfigFormatFallback {
+		keyed, warnings, err := f.readConfigFormat(bytes.NewReader(b), path, format)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		} else if found, _ := f.matchConfigKeys(keyed); len(found) > 0 {
+			return keyed, format, warnings, nil
+		} else if firstKeyed == nil {
+			firstKeyed, firstFormat, firstWarnings = keyed, format, warnings
+		}
+	}
+	if firstKeyed == nil {
+		return nil, ConfigFormatAuto, nil, firstErr
+	}
+	return firstKeyed, firstFormat, firstWarnings, nil
+}
+
+// readConfigFormat reads the config file from the given reader in the given
+// format
+func (f *Lever) readConfigFormat(
+	r io.Reader, path string, format ConfigFormat,
+) (
+	map[string][]string, []string, error,
+) {
+	if format == ConfigFormatJSON {
+		keyed, err := f.readConfigJSON(r)
+		return keyed, nil, err
+	}
+	return f.readConfigKeys(r, path, 0)
+}
+
+// readConfigDecoder is like readConfigKeys, but uses the ConfigDecoder from
+// Opts. The names it returns are converted back into the keys they would have in
+// a native config file
+func (f *Lever) readConfigDecoder(r io.Reader) (map[string][]string, error) {
+	var lr *io.LimitedReader
+	if f.o.MaxConfigBytes > 0 {
+		lr = &io.LimitedReader{R: r, N: f.o.MaxConfigBytes + 1}
+		r = lr
+	}
+
+	// The decoder only sees a truncated file when the limit is exceeded, so
+	// that takes precedence over whatever error it gave
+	decoded, err := f.o.ConfigDecoder.Decode(r, f.expected)
+	if lr != nil && lr.N == 0 {
+		return nil, fmt.Errorf("exceeds maximum size of %d bytes", f.o.MaxConfigBytes)
+	} else if err != nil {
+		return nil, err
+	}
+
+	keyed := make(map[string][]string, len(decoded))
+	for name, vals := range decoded {
+		if p, ok := f.expected[name]; ok {
+			name = p.configName()
+		}
+		keyed[name] = append(keyed[name], vals...)
+	}
+	return keyed, nil
+}
+
+// jsonArrayValues returns the elements of the given string as config values if
+// it's a JSON array, or false if it isn't
+func jsonArrayValues(s string) ([]string, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(s), "[") {
+		return nil, false
+	}
+	var arr []json.RawMessage
+	if err := json.Unmarshal([]byte(s), &arr); err != nil {
+		return nil, false
+	}
+	vals := make([]string, 0, len(arr))
+	for _, raw := range arr {
+		if v, ok := jsonConfigValue(raw); ok {
+			vals = append(vals, v)
+		}
+	}
+	return vals, true
+}
+
+// jsonConfigValue returns the given JSON value as a config value, and false if
+// it's null
+func jsonConfigValue(raw json.RawMessage) (string, bool) {
+	var s string
+	if bytes.Equal(raw, []byte("null")) {
+		return "", false
+	} else if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	return string(raw), true
+}
+
+// readConfigJSON is like readConfigKeys, but for a ConfigFormatJSON file
+func (f *Lever) readConfigJSON(r io.Reader) (map[string][]string, error) {
+	if f.o.MaxConfigBytes > 0 {
+		r = io.LimitReader(r, f.o.MaxConfigBytes+1)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	} else if f.o.MaxConfigBytes > 0 && int64(len(b)) > f.o.MaxConfigBytes {
+		return nil, fmt.Errorf("exceeds maximum size of %d bytes", f.o.MaxConfigBytes)
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+
+	keyed := map[string][]string{}
+	for name, raw := range obj {
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			arr = []json.RawMessage{raw}
+		}
+
+		vals := make([]string, 0, len(arr))
+		for _, raw := range arr {
+			if v, ok := jsonConfigValue(raw); ok {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) > 0 {
+			keyed[name] = vals
+		}
+	}
+	return keyed, nil
+}
+
+// readInclude reads the config file included with the given path by the config
+// file at fromPath, at the given depth
+func (f *Lever) readInclude(
+	path, fromPath string, depth int,
+) (
+	map[string][]string, []string, error,
+) {
+	if depth >= maxIncludeDepth {
+		return nil, nil, fmt.Errorf(
+			"includes nested more than %d deep, there may be a cycle", maxIncludeDepth,
+		)
+	} else if !filepath.IsAbs(path) && fromPath != "" && fromPath != "-" {
+		path = filepath.Join(filepath.Dir(fromPath), path)
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening include %s: %s", path, err)
+	}
+	defer fd.Close()
+
+	if err := f.checkConfigPerms(fd); err != nil {
+		return nil, nil, err
+	}
+
+	keyed, warnings, err := f.readConfigKeys(fd, path, depth+1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading include %s: %s", path, err)
+	}
+	return keyed, prefixWarnings(path, warnings), nil
+}
+
+// commentPrefixes returns the prefixes which begin a comment in the config
+// file, see ConfigCommentPrefixes
+func (f *Lever) commentPrefixes() []string {
+	prefixes := []string{"#"}
+	for _, prefix := range f.o.ConfigCommentPrefixes {
+		if prefix != "" && prefix != "#" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// isConfigComment returns whether the given line from the config file, with its
+// leading whitespace trimmed, is a comment
+func (f *Lever) isConfigComment(line string) bool {
+	for _, prefix := range f.commentPrefixes() {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripConfigComment returns the given value from the config file with any
+// comment at the end of it removed. A comment must be preceded by whitespace
+// which isn't escaped, and can only begin once the value has, so a value which
+// itself starts with a comment prefix is kept whole
+func (f *Lever) stripConfigComment(s string) string {
+	prefixes := f.commentPrefixes()
+	start := len(s) - len(strings.TrimLeft(s, " \t"))
+	for i := start; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		} else if s[i] != ' ' && s[i] != '\t' {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(s[i+1:], prefix) {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// unescapeConfigValue trims the whitespace surrounding a value from the config
+// file and handles its escape sequences. "\ " is a space which won't be
+// trimmed, and "\\" is a single backslash. Any other backslash is left as-is
+func unescapeConfigValue(s string) string {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	out := make([]byte, 0, len(s))
+
+	// keep is the length of out up to and including the last byte which
+	// mustn't be trimmed
+	var keep int
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && (s[i+1] == ' ' || s[i+1] == '\\') {
+			i++
+			out = append(out, s[i])
+			keep = len(out)
+			continue
+		}
+		out = append(out, c)
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			keep = len(out)
+		}
+	}
+	return string(out[:keep])
+}
+
+// matchConfigKeys takes the output of readConfigKeys and splits it into the
+// values for expected params, keyed by their Name, and the values whose keys
+// didn't match any expected param, keyed as they were in the config file
+func (f *Lever) matchConfigKeys(
+	keyed map[string][]string,
+) (
+	found, unknown map[string][]string,
+) {
+	found = map[string][]string{}
+	unknown = map[string][]string{}
+	for name, vals := range keyed {
+		var matched bool
+		for n, p := range f.expected {
+			if p.configName() != name {
+				continue
+			}
+			found[n] = append(found[n], vals...)
+			matched = true
+		}
+		if !matched {
+			unknown[name] = vals
+		}
+	}
+	return found, unknown
+}
+
 // Will attempt to find and read the config file based on the expected
 // parameters (namely the default config file) and the found parameter values so
-// far. It will return the config file's found values, or nil if no config file
-// is specified
+// far. If no config file was given explicitly and there are paths to search
+// (see ConfigSearchPaths) the first of those which exists is used before the
+// default config file. A config file of "-" is read from stdin. If more
+// than one config file was given they're all read, in order, with the values
+// for any key in a later file replacing those in earlier ones. It will return
+// the config files' values as returned by readConfigKeys along with the path
+// and format of the last one read, or nil if no config file is specified
 func (f *Lever) maybeReadConfig(
 	found map[string][]string,
 ) (
-	map[string][]string, error,
+	map[string][]string, string, ConfigFormat, []string, error,
 ) {
-	var fn string
-	if c, ok := found["--config"]; ok && c[0] != "" {
-		fn = c[0]
-	} else if def := f.expected["--config"].Default; def != "" {
-		fn = def
-	} else {
-		return nil, nil
+	var fns []string
+	for _, c := range found[f.configFlag] {
+		if c != "" {
+			fns = append(fns, c)
+		}
+	}
+
+	if len(fns) == 0 {
+		fn, err := f.fallbackConfigFile()
+		if err != nil || fn == "" {
+			return nil, "", ConfigFormatAuto, nil, err
+		}
+		fns = []string{fn}
+	}
+
+	var keyed map[string][]string
+	var path string
+	var format ConfigFormat
+	var warnings []string
+	for _, fn := range fns {
+		fnKeyed, fnFormat, fnWarnings, err := f.readConfigFile(fn)
+		if err != nil {
+			return nil, "", ConfigFormatAuto, nil, err
+		} else if fnKeyed == nil {
+			continue
+		}
+
+		if keyed == nil {
+			keyed = fnKeyed
+		} else {
+			keyed = layerKeyed(keyed, fnKeyed)
+		}
+		path, format = fn, fnFormat
+		warnings = append(warnings, fnWarnings...)
+	}
+	return keyed, path, format, warnings, nil
+}
+
+// fallbackConfigFile returns the config file to read when none was given
+// explicitly, or "" if there isn't one
+func (f *Lever) fallbackConfigFile() (string, error) {
+	var searched []string
+	for _, path := range f.configSearch {
+		path, ok := expandConfigSearchPath(path)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return path, nil
+		}
+		searched = append(searched, path)
+	}
+
+	if def := f.expected[f.configFlag].Default; def != "" {
+		return def, nil
+	} else if len(f.configSearch) > 0 && !f.o.AllowMissingConfigFile {
+		return "", fmt.Errorf(
+			"no config file found, searched: %s", strings.Join(searched, ", "),
+		)
+	} else if f.requireConfig {
+		return "", errors.New("no config file given")
+	}
+	return "", nil
+}
+
+// expandConfigSearchPath expands the environment variables in a config search
+// path, returning false if any of them aren't set or the path is empty
+func expandConfigSearchPath(path string) (string, bool) {
+	ok := true
+	path = os.Expand(path, func(key string) string {
+		v, set := os.LookupEnv(key)
+		ok = ok && set
+		return v
+	})
+	return path, ok && path != ""
+}
+
+// readConfigFile reads the config file at the given path, or stdin if the path
+// is "-", returning its values as returned by readConfigKeys along with the
+// format they were read in. If the file doesn't exist and AllowMissingConfigFile
+// is set nil is returned
+func (f *Lever) readConfigFile(
+	fn string,
+) (
+	map[string][]string, ConfigFormat, []string, error,
+) {
+	if fn == "-" {
+		keyed, format, warnings, err := f.decodeConfigFormat(f.input(), "")
+		if err != nil {
+			return nil, ConfigFormatAuto, nil, fmt.Errorf("error reading stdin: %s", err)
+		} else if keyed == nil {
+			keyed = map[string][]string{}
+		}
+		return keyed, format, prefixWarnings("stdin", warnings), nil
+	}
+
+	fd, err := os.Open(fn)
+	if err != nil {
+		if f.o.AllowMissingConfigFile && os.IsNotExist(err) {
+			return nil, ConfigFormatAuto, nil, nil
+		}
+		return nil, ConfigFormatAuto, nil, fmt.Errorf("error opening %s: %s", fn, err)
+	}
+	defer fd.Close()
+
+	if err := f.checkConfigPerms(fd); err != nil {
+		return nil, ConfigFormatAuto, nil, err
+	}
+
+	keyed, format, warnings, err := f.decodeConfigFormat(fd, fn)
+	if err != nil {
+		return nil, ConfigFormatAuto, nil, fmt.Errorf("error reading %s: %s", fn, err)
+	} else if keyed == nil {
+		keyed = map[string][]string{}
+	}
+	return keyed, format, prefixWarnings(fn, warnings), nil
+}
+
+// checkConfigPerms returns an error if RequireSecureConfigPerms is set and the
+// given config file can be read by its group or others
+func (f *Lever) checkConfigPerms(fd *os.File) error {
+	if !f.o.RequireSecureConfigPerms || runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		return fmt.Errorf("error checking %s: %s", fd.Name(), err)
+	} else if perm := fi.Mode().Perm(); perm&0044 != 0 {
+		return fmt.Errorf(
+			"%s is readable by group or others (mode %#o)", fd.Name(), perm,
+		)
+	}
+	return nil
+}
+
+// profileConfigPath returns the path of the config file for the given profile
+// of the config file at path
+func profileConfigPath(path, profile string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + profile + ext
+}
+
+// maybeReadProfile reads the config file for the profile given by --profile,
+// if any, using the given sources and config file read by maybeReadConfig to
+// determine it. The values from the profile's file are layered on top of the
+// given ones from the config file and returned
+func (f *Lever) maybeReadProfile(
+	srcs []sourceValues,
+	configKeyed map[string][]string,
+	configPath string,
+	environ []string,
+) (
+	map[string][]string, []string, error,
+) {
+	foundConfig, _ := f.matchConfigKeys(configKeyed)
+	srcs = append(srcs,
+		sourceValues{SourceConfig, foundConfig},
+		sourceValues{SourceDefault, f.defaultsAsFound(environ)},
+	)
+	found, _ := f.resolveAll(srcs)
+	profile, ok := found["--profile"]
+	if !ok || profile[0] == "" {
+		return configKeyed, nil, nil
+	} else if configPath == "" || configPath == "-" {
+		return nil, nil, fmt.Errorf("profile %q given without a config file", profile[0])
 	}
 
-	fd, err := os.Open(fn)
-	if err != nil {
-		if f.o.AllowMissingConfigFile && os.IsNotExist(err) {
-			return nil, nil
+	fn := profileConfigPath(configPath, profile[0])
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening %s: %s", fn, err)
+	}
+	defer fd.Close()
+
+	if err := f.checkConfigPerms(fd); err != nil {
+		return nil, nil, err
+	}
+
+	keyed, warnings, err := f.decodeConfigKeys(fd, fn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %s", fn, err)
+	}
+
+	return layerKeyed(configKeyed, keyed), prefixWarnings(fn, warnings), nil
+}
+
+// layerKeyed returns the values of both maps as returned by readConfigKeys, with
+// the values for any key in top replacing those for the same key in base
+func layerKeyed(base, top map[string][]string) map[string][]string {
+	layered := make(map[string][]string, len(base)+len(top))
+	for k, vals := range base {
+		layered[k] = vals
+	}
+	for k, vals := range top {
+		layered[k] = vals
+	}
+	return layered
+}
+
+// readConfigReader reads the ConfigReader given in Opts, if any. Since it can
+// only be read once what's read is kept for later parses
+func (f *Lever) readConfigReader() (map[string][]string, []string, error) {
+	if f.o.ConfigReader == nil || f.readerKeyed != nil {
+		return f.readerKeyed, nil, nil
+	}
+	keyed, warnings, err := f.decodeConfigKeys(f.o.ConfigReader, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading ConfigReader: %s", err)
+	}
+	f.readerKeyed = keyed
+	return keyed, prefixWarnings("ConfigReader", warnings), nil
+}
+
+func prefixWarnings(prefix string, warnings []string) []string {
+	for i := range warnings {
+		warnings[i] = prefix + ": " + warnings[i]
+	}
+	return warnings
+}
+
+// formats a strings as a standard environment variable, changing all characters
+// to uppercase and switching - for _
+func envify(s string) string {
+	s = strings.ToUpper(s)
+	return strings.Replace(s, "-", "_", -1)
+}
+
+// readEnv reads any expected params out of the given environment (each element
+// of the form key=val) and returns the ones found, or an error if something
+// goes wrong
+func (f *Lever) readEnv(environ []string) map[string][]string {
+	envMap := map[string]string{}
+	for _, env := range environ {
+		if parts := strings.SplitN(env, "=", 2); len(parts) == 2 {
+			envMap[parts[0]] = parts[1]
+		}
+	}
+
+	found := map[string][]string{}
+	for _, p := range f.expected {
+		for _, name := range f.envNames(p) {
+			if val, ok := envMap[name]; ok {
+				if p.Flag && p.EnvPresenceIsTrue {
+					val = "true"
+				}
+				if p.EnvSeparator != "" {
+					found[p.Name] = strings.Split(val, p.EnvSeparator)
+				} else {
+					found[p.Name] = []string{val}
+				}
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// envPrefix returns the prefix which all environment variables read by lever
+// start with
+func (f *Lever) envPrefix() string {
+	sep := f.o.EnvPrefixSeparator
+	if sep == "" {
+		sep = "_"
+	}
+	if f.o.EnvPrefix != "" {
+		return envify(f.o.EnvPrefix) + sep
+	}
+	return envify(f.appName) + sep
+}
+
+// DefaultEnvName returns the name of the environment variable which a param's
+// value is read from when EnvNameFunc isn't set in Opts. This is the param's
+// name in the config file, prefixed with the app's name, uppercased and with
+// - replaced with _, for example "MYAPP_FOO_BAR" for "--foo-bar"
+func DefaultEnvName(appName string, p *Param) string {
+	return envify(appName) + "_" + envify(p.configName())
+}
+
+// envName returns the name of the environment variable the param's value is
+// read from
+func (f *Lever) envName(p *Param) string {
+	if f.o.EnvNameFunc != nil {
+		return f.o.EnvNameFunc(f.appName, p)
+	}
+	return f.envPrefix() + envify(p.configName())
+}
+
+// envNames returns the names of the environment variables the param's value
+// may be read from, in order of preference
+func (f *Lever) envNames(p *Param) []string {
+	if p.DisallowInEnv {
+		return nil
+	}
+	return f.knownEnvNames(p)
+}
+
+// knownEnvNames is like envNames, but ignores DisallowInEnv, so that those
+// variables are still recognized as belonging to the param
+func (f *Lever) knownEnvNames(p *Param) []string {
+	names := []string{f.envName(p)}
+	if len(p.EnvNames) > 0 {
+		names = p.EnvNames
+	}
+	if mapped := f.mappedEnv[p.Name]; len(mapped) > 0 {
+		names = append(append([]string{}, names...), mapped...)
+	}
+	return names
+}
+
+// MapEnv makes the environment variable of the given name set each of the
+// params with the given names (or aliases), for example so that a single
+// LOG_LEVEL variable can configure several subsystems. A param's own
+// environment variables take precedence over ones mapped to it, and if several
+// are mapped to it the one mapped first does. MapEnv panics if any of the
+// params haven't been added
+func (f *Lever) MapEnv(envName string, paramNames ...string) {
+	if f.mappedEnv == nil {
+		f.mappedEnv = map[string][]string{}
+	}
+	for _, name := range paramNames {
+		p, ok := f.expectedFull[name]
+		if !ok {
+			panic(fmt.Sprintf("lever: MapEnv(%q) given unknown param %q", envName, name))
+		}
+		f.mappedEnv[p.Name] = append(f.mappedEnv[p.Name], envName)
+	}
+}
+
+// expectedEnv returns the expected params keyed by the name of each environment
+// variable which belongs to them, including those of params with DisallowInEnv
+// set, whose values are ignored, and the DefaultEnv of each param
+func (f *Lever) expectedEnv() map[string]*Param {
+	expectedEnv := map[string]*Param{}
+	for _, p := range f.expected {
+		for _, name := range f.knownEnvNames(p) {
+			expectedEnv[name] = p
+		}
+		if p.DefaultEnv != "" {
+			expectedEnv[p.DefaultEnv] = p
+		}
+	}
+	return expectedEnv
+}
+
+// unknownEnvErr returns an error for each variable in the given environment
+// which starts with the env prefix but doesn't match an expected param
+func (f *Lever) unknownEnvErr(environ []string) MultiError {
+	prefix := f.envPrefix()
+	expectedEnv := f.expectedEnv()
+
+	var errs MultiError
+	for _, env := range environ {
+		name := strings.SplitN(env, "=", 2)[0]
+		if _, ok := expectedEnv[name]; ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		errs = append(errs, &ParamError{
+			Param:   name,
+			Source:  SourceEnv,
+			Message: "unknown environment variable",
+		})
+	}
+	return errs
+}
+
+// lookupEnv returns the value of the variable of the given name in the given
+// environment (each element of the form key=val)
+func lookupEnv(environ []string, name string) (string, bool) {
+	for _, env := range environ {
+		if parts := strings.SplitN(env, "=", 2); len(parts) == 2 && parts[0] == name {
+			return parts[1], true
+		}
+	}
+	return "", false
+}
+
+// defaultsAsFound returns all the expected param's default values as if they
+// were pulled from a real source (like cli or config file). This is used in the
+// final part of Parse(), to fill out any values which weren't specified
+// anywhere. The given environment is used for params with DefaultEnv set
+func (f *Lever) defaultsAsFound(environ []string) map[string][]string {
+	found := map[string][]string{}
+	for n, p := range f.expected {
+		if v, ok := lookupEnv(environ, p.DefaultEnv); ok && p.DefaultEnv != "" {
+			found[n] = []string{v}
+		} else if p.DefaultMulti != nil {
+			found[n] = p.DefaultMulti
+		} else if p.Default != "" {
+			found[n] = []string{p.Default}
+		}
+	}
+	return found
+}
+
+// sourceValues holds the values read from a single source, keyed by param Name
+type sourceValues struct {
+	source Source
+	values map[string][]string
+}
+
+// resolve combines the values for the param from the given sources, which must
+// be in order of precedence (highest first), according to its MultiMerge. The
+// combined values are returned along with the source of highest precedence
+// which set them. Default values are only used if no other source set the
+// param. If the param has a SourcePriority the sources are reordered by it first
+func (p *Param) resolve(srcs []sourceValues) ([]string, Source, bool) {
+	srcs = p.prioritize(srcs)
+	var vals []string
+	var src Source
+	for _, s := range srcs {
+		vs, ok := s.values[p.Name]
+		if !ok {
+			continue
+		} else if src == "" {
+			vals, src = vs, s.source
+			if p.MultiMerge == MultiMergeReplace {
+				break
+			}
+			continue
+		} else if s.source == SourceDefault {
+			break
+		}
+
+		switch p.MultiMerge {
+		case MultiMergeAppend:
+			vals = append(append([]string{}, vs...), vals...)
+		case MultiMergePrepend:
+			vals = append(append([]string{}, vals...), vs...)
+		}
+	}
+	return vals, src, src != ""
+}
+
+// prioritize returns the given sources reordered according to the param's
+// SourcePriority, if it has one
+func (p *Param) prioritize(srcs []sourceValues) []sourceValues {
+	if len(p.SourcePriority) == 0 {
+		return srcs
+	}
+
+	out := make([]sourceValues, 0, len(srcs))
+	listed := map[Source]bool{}
+	for _, src := range p.SourcePriority {
+		if listed[src] {
+			continue
+		}
+		listed[src] = true
+		for _, s := range srcs {
+			if s.source == src {
+				out = append(out, s)
+			}
+		}
+	}
+	for _, s := range srcs {
+		if !listed[s.source] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// resolveAll resolves the values of all expected params from the given
+// sources, returning the values and the source they came from, keyed by Name
+func (f *Lever) resolveAll(
+	srcs []sourceValues,
+) (
+	map[string][]string, map[string]Source,
+) {
+	found := map[string][]string{}
+	foundSource := map[string]Source{}
+	for n, p := range f.expected {
+		if vals, src, ok := p.resolve(srcs); ok {
+			found[n] = vals
+			foundSource[n] = src
+		}
+	}
+	return found, foundSource
+}
+
+// Source describes where the value of a param came from
+type Source string
+
+// All possible Source values
+const (
+	SourceCLI     Source = "cli"
+	SourceEnv     Source = "env"
+	SourceConfig  Source = "config"
+	SourceDefault Source = "default"
+
+	// The value was given through Seed
+	SourceSeed Source = "seed"
+
+	// The value came from one of the Providers in Opts
+	SourceProvider Source = "provider"
+
+	// The value was computed by a function given to AddComputed
+	SourceComputed Source = "computed"
+)
+
+// ParamError describes a problem with the value given for a single param
+type ParamError struct {
+	// The Name of the param, or the name which was used if it didn't match any
+	// expected param
+	Param string `json:"param"`
+
+	// Where the problematic value came from, may be empty if the problem isn't
+	// specific to one source
+	Source Source `json:"source"`
+
+	Message string `json:"message"`
+}
+
+func (e *ParamError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("%s: %s", e.Param, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Source, e.Param, e.Message)
+}
+
+// MultiError is returned when more than one problem may have been found at
+// once, for example when validating a config file
+type MultiError []error
+
+func (me MultiError) Error() string {
+	strs := make([]string, len(me))
+	for i := range me {
+		strs[i] = me[i].Error()
+	}
+	return strings.Join(strs, "; ")
+}
+
+// err returns the MultiError as an error, or nil if it's empty
+func (me MultiError) err() error {
+	if len(me) == 0 {
+		return nil
+	}
+	return me
+}
+
+// ConfigFormat describes the format a config file is written in
+type ConfigFormat int
+
+// All possible ConfigFormat values
+const (
+	// The format is chosen by the config file's extension: ".json" files are
+	// read as ConfigFormatJSON and all others as ConfigFormatNative
+	ConfigFormatAuto ConfigFormat = iota
+
+	// Each line is of the form "name: value", as shown by Example()
+	ConfigFormatNative
+
+	// The file holds a single JSON object, whose keys are param names as they
+	// would appear in the native format. Each value is either a single value
+	// or an array of values. Strings are used as-is, and any other kind of
+	// value (for example a number or object) is used as its JSON text
+	ConfigFormatJSON
+)
+
+// ConfigDecoder reads the values out of a config file written in some format
+// lever doesn't support itself, for example YAML or TOML
+type ConfigDecoder interface {
+
+	// Decode reads all values from the config file. The given params are
+	// those expected, keyed by Name, and must not be modified. The returned
+	// map must be keyed by full param Name, for example "--foo", and
+	// multiple values for a param are given in order. Keys which don't match an
+	// expected param are treated like unknown keys in a native config file
+	Decode(r io.Reader, expected map[string]*Param) (map[string][]string, error)
+}
+
+// ErrorFormat describes how errors are written by Parse before it exits
+type ErrorFormat int
+
+// All possible ErrorFormat values
+const (
+	// The error's message is written as-is
+	ErrorFormatText ErrorFormat = iota
+
+	// The error is written as a JSON array of objects, each with the fields
+	// "param", "source", and "message" (see ParamError). Errors which don't
+	// pertain to a single param have only the "message" field filled in
+	ErrorFormatJSON
+)
+
+// ErrHelp is returned from ParseArgs when the --help flag was set. The help
+// output will have already been written to the Output given in Opts
+var ErrHelp = errors.New("help requested")
+
+// ErrExample is returned from ParseArgs when the --example flag was set. The
+// example configuration will have already been written to the Output given in
+// Opts
+var ErrExample = errors.New("example requested")
+
+// ErrDumpEnv is returned from ParseArgs when the --dump-env flag was set. The
+// output of DumpEnv will have already been written to the Output given in Opts
+var ErrDumpEnv = errors.New("environment dump requested")
+
+// output returns the writer which help and example output should be written to
+func (f *Lever) output() io.Writer {
+	if f.o.Output != nil {
+		return f.o.Output
+	}
+	return os.Stdout
+}
+
+// errOutput returns the writer which errors from Parse should be written to
+func (f *Lever) errOutput() io.Writer {
+	if f.o.ErrOutput != nil {
+		return f.o.ErrOutput
+	}
+	return os.Stderr
+}
+
+// input returns the reader which a config file of "-" should be read from
+func (f *Lever) input() io.Reader {
+	if f.o.Input != nil {
+		return f.o.Input
+	}
+	return os.Stdin
+}
+
+// Parse looks at all available sources of param values (command line,
+// environment variables, configuration file) and puts together all the
+// discovered values. Once this returns it is possible to retrieve values for
+// specific params. If the --help or --example flags are set on the command line
+// their associated output is written to the Output given in Opts (stdout by
+// default) and os.Exit(0) will be called. If parsing fails the error is
+// written to the ErrOutput given in Opts (stderr by default) and os.Exit(1)
+// will be called.
+func (f *Lever) Parse() {
+	err := f.ParseErr()
+	if err == ErrHelp || err == ErrExample || err == ErrDumpEnv {
+		syncWriter(f.output())
+		os.Exit(0)
+	} else if err != nil {
+		w := f.errOutput()
+		f.writeError(w, err)
+		syncWriter(w)
+		os.Exit(1)
+	}
+}
+
+// syncWriter flushes the given writer to disk if it's a file, so that nothing
+// written to it is lost when the process exits
+func syncWriter(w io.Writer) {
+	if fd, ok := w.(*os.File); ok {
+		fd.Sync()
+	}
+}
+
+// writeError writes the given error from parsing to the given writer, followed
+// by the help message if HelpOnError is set
+func (f *Lever) writeError(w io.Writer, err error) {
+	fmt.Fprintln(w, f.formatError(err))
+	if f.o.HelpOnError && f.o.ErrorFormat != ErrorFormatJSON {
+		fmt.Fprint(w, f.Help())
+	}
+}
+
+// formatError returns the given error as it should be written out by Parse,
+// according to the ErrorFormat in Opts
+func (f *Lever) formatError(err error) string {
+	if f.o.ErrorFormat != ErrorFormatJSON {
+		return err.Error()
+	}
+
+	var errs MultiError
+	if me, ok := err.(MultiError); ok {
+		errs = me
+	} else {
+		errs = MultiError{err}
+	}
+
+	pes := make([]*ParamError, len(errs))
+	for i, err := range errs {
+		if pe, ok := err.(*ParamError); ok {
+			pes[i] = pe
+		} else {
+			pes[i] = &ParamError{Message: err.Error()}
+		}
+	}
+
+	b, jerr := json.Marshal(pes)
+	if jerr != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// ParseErr is like Parse, but instead of exiting the process it returns
+// ErrHelp, ErrExample or ErrDumpEnv if the corresponding flag was set, after
+// writing its output, or any error encountered while parsing. It can be called
+// more than once, for example to reload the configuration.
+func (f *Lever) ParseErr() error {
+	return f.ParseArgs(os.Args[1:], os.Environ())
+}
+
+// ParseRequireConfig is like ParseErr, but overrides AllowMissingConfigFile for
+// this parse only. If required is true a config file must be given and must
+// exist, otherwise one which is given but doesn't exist is ignored. This
+// allows, for example, a config file to be mandatory in production but not
+// during development
+func (f *Lever) ParseRequireConfig(required bool) error {
+	o, saved := *f.o, f.o
+	o.AllowMissingConfigFile = !required
+	f.o, f.requireConfig = &o, required
+	defer func() { f.o, f.requireConfig = saved, false }()
+	return f.ParseErr()
+}
+
+// ParseArgs is like Parse, but rather than looking at os.Args and os.Environ()
+// it uses the given command line arguments (minus the call string) and
+// environment (each element of the form key=val). Instead of exiting the
+// process it returns ErrHelp or ErrExample if the --help or --example flags
+// were set, after writing their output, or any error encountered while reading
+// the configuration file.
+func (f *Lever) ParseArgs(args, environ []string) error {
+	return f.parse(args, environ, true)
+}
+
+// ParseFlagsOnly resolves only the params which are flags, using the given
+// command line arguments (minus the call string) and the environment. Params
+// which take a value, along with their values, are left in ParamRest() as if
+// they weren't expected. The config file isn't read and the flags which print
+// something and exit, like --help, are only resolved. Afterwards more params
+// may be added, for example depending on which flags were set, before a full
+// parse using Parse or one of its variants
+func (f *Lever) ParseFlagsOnly(args []string) error {
+	return f.ParseFlagsOnlyArgs(args, os.Environ())
+}
+
+// ParseFlagsOnlyArgs is like ParseFlagsOnly, but rather than looking at
+// os.Environ() it uses the given environment (each element of the form
+// key=val), like ParseArgs
+func (f *Lever) ParseFlagsOnlyArgs(args, environ []string) error {
+	f.flagsOnly = true
+	foundCLI, unknown, verbatim, err := f.readCLIRest(args)
+	f.flagsOnly = false
+	if err != nil {
+		return err
+	}
+
+	found, foundSource := f.resolveAll([]sourceValues{
+		{SourceCLI, f.overrides},
+		{SourceCLI, foundCLI},
+		{SourceEnv, f.readEnv(environ)},
+		{SourceSeed, f.seeded},
+		{SourceDefault, f.defaultsAsFound(environ)},
+	})
+
+	var errs MultiError
+	for _, p := range f.sortedExpected() {
+		if !p.Flag {
+			delete(found, p.Name)
+			delete(foundSource, p.Name)
+		} else if err := p.validateValues(found[p.Name]); err != nil {
+			errs = append(errs, &ParamError{
+				Param:   p.Name,
+				Source:  foundSource[p.Name],
+				Message: err.Error(),
+			})
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	f.found = found
+	f.foundSource = foundSource
+	f.remaining = append(append([]string{}, unknown...), verbatim...)
+	f.restSplit = len(unknown)
+	return nil
+}
+
+// ParseWithoutCLI is like Parse, except that values are only read from the
+// environment and config file (and defaults). The given args, which would
+// normally be command line arguments, are left entirely to the caller and are
+// returned as-is by ParamRest. Values which the caller reads from the command
+// line itself can be given to the Lever using Override. Since the command line
+// isn't read --help and --example have no effect, and the config file can only
+// be given through the environment or DefaultConfigFile.
+func (f *Lever) ParseWithoutCLI(args []string) error {
+	return f.parse(args, os.Environ(), false)
+}
+
+// ParseWithConfigSearch is like Parse, except that the given paths replace
+// ConfigSearchPaths in Opts, and are searched in the same way if no config file
+// is given on the command line or in the environment. If none of them exist and
+// there's no DefaultConfigFile parsing fails with an error listing them all,
+// unless AllowMissingConfigFile is set in Opts, in which case parsing continues
+// without a config file. The config file which was used can be retrieved using
+// ConfigFilePath.
+func (f *Lever) ParseWithConfigSearch(paths []string) error {
+	f.configSearch = paths
+	return f.ParseArgs(os.Args[1:], os.Environ())
+}
+
+// Parsed returns whether a parse has completed successfully, or a Snapshot has
+// been restored. Until then the Param* methods return no values
+func (f *Lever) Parsed() bool {
+	return f.frozen
+}
+
+// Warnings returns descriptions of any problems encountered during the most
+// recent parse which weren't severe enough to make it fail, for example config
+// file lines skipped because LenientConfig is set
+func (f *Lever) Warnings() []string {
+	return f.warnings
+}
+
+// ConfigFormatUsed returns the format the config file was read in during the
+// most recent parse. This is mostly useful with ConfigFormatFallback. If no
+// config file was read, or ConfigDecoder was used, ConfigFormatAuto is returned
+func (f *Lever) ConfigFormatUsed() ConfigFormat {
+	return f.configFileFormat
+}
+
+// ConfigFilePath returns the path of the config file which was read during the
+// most recent parse, or "" if none was. If the config file was read from stdin
+// "-" is returned. If more than one config file was read the last is returned
+func (f *Lever) ConfigFilePath() string {
+	return f.configPath
+}
+
+// parse implements ParseArgs and ParseWithoutCLI. If useCLI is false args are
+// not read as command line arguments, but are kept as remaining
+func (f *Lever) parse(args, environ []string, useCLI bool) error {
+	var foundCLI map[string][]string
+	var unknown, verbatim []string
+	var cliErr error
+	if useCLI {
+		foundCLI, unknown, verbatim, cliErr = f.readCLIRest(args)
+	} else {
+		foundCLI = map[string][]string{}
+		unknown, verbatim = []string{}, append([]string{}, args...)
+		args = nil
+	}
+	remaining := append(append([]string{}, unknown...), verbatim...)
+
+	if help, ok := foundCLI[f.helpFlag]; ok && help[0] == "true" {
+		fmt.Fprint(f.output(), f.Help())
+		return ErrHelp
+	}
+
+	example, ok := foundCLI[f.exampleFlag]
+	if ok && example[0] == "true" && !f.o.DisallowConfigFile {
+		fmt.Fprint(f.output(), f.Example())
+		return ErrExample
+	}
+
+	dumpEnv, ok := foundCLI[dumpEnvFlag]
+	if ok && dumpEnv[0] == "true" && f.o.DumpEnvFlag {
+		fmt.Fprint(f.output(), f.DumpEnv(environ))
+		return ErrDumpEnv
+	}
+
+	if cliErr != nil {
+		return cliErr
+	}
+
+	if f.o.StrictEnv {
+		if err := f.unknownEnvErr(environ).err(); err != nil {
+			return err
+		}
+	}
+
+	srcs := []sourceValues{
+		{SourceCLI, f.overrides},
+		{SourceCLI, foundCLI},
+		{SourceEnv, f.readEnv(environ)},
+	}
+
+	var configKeyed, unknownConfig map[string][]string
+	var configPath string
+	var configFormat ConfigFormat
+	var warnings []string
+	if !f.o.DisallowConfigFile {
+		found, _ := f.resolveAll(srcs)
+		var err error
+		configKeyed, configPath, configFormat, warnings, err = f.maybeReadConfig(found)
+		if err != nil {
+			return err
+		}
+
+		readerKeyed, readerWarnings, err := f.readConfigReader()
+		if err != nil {
+			return err
+		} else if readerKeyed != nil {
+			configKeyed = layerKeyed(readerKeyed, configKeyed)
+			warnings = append(readerWarnings, warnings...)
+		}
+
+		if f.o.ConfigProfiles {
+			var profileWarnings []string
+			configKeyed, profileWarnings, err = f.maybeReadProfile(
+				srcs, configKeyed, configPath, environ,
+			)
+			if err != nil {
+				return err
+			}
+			warnings = append(warnings, profileWarnings...)
+		}
+
+		var foundConfig map[string][]string
+		foundConfig, unknownConfig = f.matchConfigKeys(configKeyed)
+		if f.o.StrictConfig {
+			if err := unknownConfigErr(unknownConfig).err(); err != nil {
+				return err
+			}
+		}
+		srcs = append(srcs, sourceValues{SourceConfig, foundConfig})
+	}
+
+	srcs = append(srcs,
+		sourceValues{SourceSeed, f.seeded},
+		sourceValues{SourceDefault, f.defaultsAsFound(environ)},
+	)
+	provided, err := f.readProviders(f.sortedExpected())
+	if err != nil {
+		return err
+	}
+	srcs = f.withProvided(srcs, provided)
+	found, foundSource := f.resolveAll(srcs)
+	f.applyAdjustments(found, foundCLI, foundSource)
+
+	if err := f.validateFound(found, foundSource); err != nil {
+		return err
+	}
+
+	err = f.computeAll(found, foundSource, unknown, unknownConfig, environ)
+	if err != nil {
+		return err
+	}
+	warnings = append(warnings, f.deprecatedWarnings(foundSource, environ)...)
+
+	if f.found != nil {
+		f.previous = f.found
+	}
+	f.found = found
+	f.foundSource = foundSource
+	f.remaining = remaining
+	f.restSplit = len(unknown)
+	f.unknownConfig = unknownConfig
+	f.args = args
+	f.environ = environ
+	f.configKeyed = configKeyed
+	f.provided = provided
+	f.providerChecked = make(map[string]bool, len(f.expected))
+	for n := range f.expected {
+		f.providerChecked[n] = true
+	}
+	f.configPath = configPath
+	f.configFileFormat = configFormat
+	f.warnings = warnings
+	f.frozen = true
+	return nil
+}
+
+// deprecatedWarnings returns a warning for each deprecated param which was set
+// by a source other than its default. If the value came from one of the
+// param's fallback EnvNames the warning names it, since that's usually the
+// thing being migrated away from
+func (f *Lever) deprecatedWarnings(
+	foundSource map[string]Source, environ []string,
+) []string {
+	var warnings []string
+	for _, p := range f.sortedExpected() {
+		src, ok := foundSource[p.Name]
+		if p.Deprecated == "" || !ok || src == SourceDefault {
+			continue
+		}
+		msg := fmt.Sprintf("%s is deprecated: %s", p.Name, p.Deprecated)
+		if src == SourceEnv {
+			if name, ok := f.fallbackEnvName(p, environ); ok {
+				msg = fmt.Sprintf(
+					"%s is deprecated, set by fallback environment variable %s: %s",
+					p.Name, name, p.Deprecated,
+				)
+			}
+		}
+		warnings = append(warnings, msg)
+	}
+	return warnings
+}
+
+// fallbackEnvName returns the name of the variable in EnvNames which the
+// param's value was read from, if it wasn't the first one
+func (f *Lever) fallbackEnvName(p *Param, environ []string) (string, bool) {
+	if p.DisallowInEnv {
+		return "", false
+	}
+	for i, name := range p.EnvNames {
+		if _, ok := lookupEnv(environ, name); ok {
+			return name, i > 0
+		}
+	}
+	return "", false
+}
+
+type computedParam struct {
+	name string
+	fn   func(*Lever) (string, error)
+}
+
+// AddComputed adds a read-only param whose value is computed by the given
+// function once all other params have been resolved, for example a value
+// derived from several others. Its value can be retrieved like any other
+// param's using ParamStr. The function is given a Lever holding the values
+// resolved so far, including those of computed params added before this one.
+// If it returns an error parsing fails with that error.
+//
+// A computed param can't be set by the user, and parsing fails if it's given
+// on the command line, in the environment, or in the config file. It doesn't
+// appear in Help() or Example()
+func (f *Lever) AddComputed(name string, fn func(f *Lever) (string, error)) {
+	if f.frozen && !f.o.AllowAddAfterParse {
+		panic(fmt.Sprintf("lever: AddComputed(%q) called after Parse", name))
+	} else if _, ok := f.expectedFull[name]; ok {
+		panic(fmt.Sprintf("lever: AddComputed(%q) given the name of an existing param", name))
+	}
+	f.computed = append(f.computed, computedParam{name: name, fn: fn})
+}
+
+// computeAll fills in the values of the computed params, after checking that
+// none of them were set by any source. unknownCLI and unknownConfig are the
+// command line args and config file keys which didn't match an expected param
+func (f *Lever) computeAll(
+	found map[string][]string,
+	foundSource map[string]Source,
+	unknownCLI []string,
+	unknownConfig map[string][]string,
+	environ []string,
+) error {
+	if len(f.computed) == 0 {
+		return nil
+	}
+
+	var errs MultiError
+	setErr := func(name string, src Source) {
+		errs = append(errs, &ParamError{
+			Param:   name,
+			Source:  src,
+			Message: "computed param can't be set",
+		})
+	}
+	for _, c := range f.computed {
+		for _, arg := range unknownCLI {
+			if strings.SplitN(arg, "=", 2)[0] == c.name {
+				setErr(c.name, SourceCLI)
+				break
+			}
+		}
+		if _, ok := lookupEnv(environ, f.envName(&Param{Name: c.name})); ok {
+			setErr(c.name, SourceEnv)
+		}
+		if _, ok := unknownConfig[trimDelim(c.name)]; ok {
+			setErr(c.name, SourceConfig)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	tmp := *f
+	tmp.found, tmp.foundSource = found, foundSource
+	for _, c := range f.computed {
+		v, err := c.fn(&tmp)
+		if err != nil {
+			return &ParamError{Param: c.name, Source: SourceComputed, Message: err.Error()}
+		}
+		found[c.name] = []string{v}
+		foundSource[c.name] = SourceComputed
+	}
+	return nil
+}
+
+// Seed gives values for params, keyed by their names (or aliases), which are
+// used if the params aren't set on the command line, in the environment, or in
+// the config file, in place of their defaults. This allows an application to
+// supply defaults from elsewhere, like its own config system, without changing
+// each Param. Values given to earlier calls of Seed are kept unless given again
+func (f *Lever) Seed(values map[string][]string) {
+	if f.seeded == nil {
+		f.seeded = map[string][]string{}
+	}
+	for name, vals := range values {
+		f.seeded[f.canonicalName(name)] = vals
+	}
+}
+
+// Resolve resolves the values of the param of the given name (or alias) from
+// the sources read during the most recent parse, without parsing again. This is
+// intended for params which were added after parsing (see AllowAddAfterParse in
+// Opts), which otherwise have no values. The resolved values are returned along
+// with the source they came from, and will be returned by the Param* methods
+// from then on. Any command line arguments used by the param will still be
+// returned by ParamRest. False is returned if the param wasn't set by any
+// source or there hasn't been a parse yet
+func (f *Lever) Resolve(name string) ([]string, Source, bool) {
+	name = f.canonicalName(name)
+	p, ok := f.expected[name]
+	if !ok || !f.frozen {
+		return nil, "", false
+	}
+
+	foundCLI, _, _ := f.readCLI(f.args)
+	foundConfig, _ := f.matchConfigKeys(f.configKeyed)
+	provided := f.provided
+	if !f.providerChecked[name] {
+		provided = f.resolveProvided(p)
+	}
+	vals, src, ok := p.resolve(f.withProvided([]sourceValues{
+		{SourceCLI, f.overrides},
+		{SourceCLI, foundCLI},
+		{SourceEnv, f.readEnv(f.environ)},
+		{SourceConfig, foundConfig},
+		{SourceSeed, f.seeded},
+		{SourceDefault, f.defaultsAsFound(f.environ)},
+	}, provided))
+
+	// Copy the maps rather than modifying them, since they may be shared with
+	// a Result
+	found := make(map[string][]string, len(f.found)+1)
+	for n, vs := range f.found {
+		found[n] = vs
+	}
+	foundSource := make(map[string]Source, len(f.foundSource)+1)
+	for n, s := range f.foundSource {
+		foundSource[n] = s
+	}
+
+	if ok {
+		found[name] = vals
+		foundSource[name] = src
+	} else {
+		delete(found, name)
+		delete(foundSource, name)
+	}
+	f.found, f.foundSource = found, foundSource
+	return vals, src, ok
+}
+
+// resolveProvided looks up the given param, which was added after the most
+// recent parse, in the Providers given in Opts, and adds what's found to what
+// was looked up during the parse. An error looking it up is added to Warnings()
+func (f *Lever) resolveProvided(p *Param) map[string][]string {
+	one, err := f.readProviders(params{p})
+	if err != nil {
+		f.warnings = append(append([]string{}, f.warnings...), err.Error())
+	}
+
+	// Copy the maps rather than modifying them, since they may be shared with
+	// a Result
+	provided := make(map[string][]string, len(f.provided)+1)
+	for n, vs := range f.provided {
+		provided[n] = vs
+	}
+	checked := make(map[string]bool, len(f.providerChecked)+1)
+	for n := range f.providerChecked {
+		checked[n] = true
+	}
+	if vals, ok := one[p.Name]; ok {
+		provided[p.Name] = vals
+	}
+	checked[p.Name] = true
+
+	f.provided, f.providerChecked = provided, checked
+	return provided
+}
+
+// Override sets the values of the param of the given name as if they had been
+// given on the command line, taking precedence over all other sources,
+// including the command line itself. If called after parsing the param's
+// values are updated immediately, as with Resolve, otherwise they will be used
+// by the next parse. The values are not validated.
+func (f *Lever) Override(name string, vals ...string) {
+	if p, ok := f.expectedFull[name]; ok {
+		name = p.Name
+	}
+	if f.overrides == nil {
+		f.overrides = map[string][]string{}
+	}
+	f.overrides[name] = vals
+	if f.frozen {
+		f.Resolve(name)
+	}
+}
+
+// validateFound checks the fully merged values of every expected param,
+// returning a MultiError of *ParamErrors describing any problems
+func (f *Lever) validateFound(
+	found map[string][]string, foundSource map[string]Source,
+) error {
+	var errs MultiError
+	for _, p := range f.sortedExpected() {
+		vals, src := found[p.Name], foundSource[p.Name]
+		if p.Required {
+			ignoreDefault := f.o.RequiredIgnoresDefault || p.Flag
+			if len(vals) == 0 || (src == SourceDefault && ignoreDefault) {
+				errs = append(errs, &ParamError{Param: p.Name, Message: "required"})
+				continue
+			}
+		}
+
+		err := p.validateValues(vals)
+		if err == nil {
+			err = p.validateCount(vals)
+		}
+		if err != nil {
+			errs = append(errs, &ParamError{
+				Param:   p.Name,
+				Source:  foundSource[p.Name],
+				Message: err.Error(),
+			})
 		}
-		return nil, fmt.Errorf("error opening %s: %s", fn, err)
 	}
+	return errs.err()
+}
 
-	foundConfig, err := f.readConfig(fd)
-	if err != nil {
-		return nil, fmt.Errorf("error reading %s: %s", fn, err)
+// unknownConfigErr returns an error for each of the given keys from the config
+// file which didn't match an expected param, in sorted order
+func unknownConfigErr(unknown map[string][]string) MultiError {
+	keys := make([]string, 0, len(unknown))
+	for k := range unknown {
+		keys = append(keys, k)
 	}
-	return foundConfig, nil
+	sort.Strings(keys)
+
+	var errs MultiError
+	for _, k := range keys {
+		errs = append(errs, &ParamError{
+			Param:   k,
+			Source:  SourceConfig,
+			Message: "unknown key",
+		})
+	}
+	return errs
 }
 
-// formats a strings as a standard environment variable, changing all characters
-// to uppercase and switching - for _
-func envify(s string) string {
-	s = strings.ToUpper(s)
-	return strings.Replace(s, "-", "_", -1)
+// validate checks that the given values are valid for the param, returning an
+// error describing the problem if not
+func (p *Param) validate(vals []string) error {
+	if p.Flag {
+		for _, v := range vals {
+			if _, err := parseFlag(v); err != nil {
+				return fmt.Errorf("invalid flag value %q", p.redacted(v))
+			}
+		}
+	}
+	return p.validateValues(vals)
 }
 
-// readEnv reads any expected params out of the given environment (each element
-// of the form key=val) and returns the ones found, or an error if something
-// goes wrong
-func (f *Lever) readEnv(environ []string) map[string][]string {
-	appNameEnv := envify(f.appName)
-	expectedEnv := map[string]*Param{}
-
-	for _, p := range f.expected {
-		n := appNameEnv + "_" + envify(p.configName())
-		expectedEnv[n] = p
+// validateValues is like validate, but only checks the constraints given on
+// the param, like Choices, and not that a flag's values are booleans. Parsing
+// has always been lenient about flag values, see ParamFlag
+func (p *Param) validateValues(vals []string) error {
+	if p.Min != nil || p.Max != nil {
+		for _, v := range vals {
+			if err := p.validateRange(v); err != nil {
+				return err
+			}
+		}
 	}
-
-	found := map[string][]string{}
-	for _, env := range environ {
-		parts := strings.SplitN(env, "=", 2)
-		name, val := parts[0], parts[1]
-		if p, ok := expectedEnv[name]; ok {
-			found[p.Name] = append(found[p.Name], val)
+	if len(p.Choices) > 0 {
+		for _, v := range vals {
+			if !p.isChoice(v) {
+				return fmt.Errorf(
+					"invalid value %q, must be one of: %s",
+					p.redacted(v), strings.Join(p.Choices, ", "),
+				)
+			}
+		}
+	}
+	if p.Validate != nil {
+		for _, v := range vals {
+			if err := p.Validate(v); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
 
-	return found
+// validateRange checks that the given value is an integer within the param's
+// Min and Max
+func (p *Param) validateRange(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", p.redacted(v))
+	}
+
+	shown := strconv.Itoa(i)
+	if p.Redact != nil || p.Sensitive {
+		shown = p.redacted(v)
+	}
+	if p.Min != nil && i < *p.Min {
+		return fmt.Errorf("%s is less than the minimum of %d", shown, *p.Min)
+	} else if p.Max != nil && i > *p.Max {
+		return fmt.Errorf("%s is greater than the maximum of %d", shown, *p.Max)
+	}
+	return nil
 }
 
-// defaultsAsFound returns all the expected param's default values as if they
-// were pulled from a real source (like cli or config file). This is used in the
-// final part of Parse(), to fill out any values which weren't specified
-// anywhere
-func (f *Lever) defaultsAsFound() map[string][]string {
-	found := map[string][]string{}
-	for n, p := range f.expected {
-		if p.DefaultMulti != nil {
-			found[n] = p.DefaultMulti
-		} else if p.Default != "" {
-			found[n] = []string{p.Default}
-		}
+// rangeHelp returns a description of the param's Min and Max for Help(), or ""
+// if it has neither
+func (p *Param) rangeHelp() string {
+	switch {
+	case p.Min != nil && p.Max != nil:
+		return fmt.Sprintf("%d to %d", *p.Min, *p.Max)
+	case p.Min != nil:
+		return fmt.Sprintf("at least %d", *p.Min)
+	case p.Max != nil:
+		return fmt.Sprintf("at most %d", *p.Max)
 	}
-	return found
+	return ""
 }
 
-func mergeFound(into, from map[string][]string) {
-	for n, p := range from {
-		if _, ok := into[n]; !ok {
-			into[n] = p
+func (p *Param) isChoice(v string) bool {
+	for _, c := range p.Choices {
+		if v == c {
+			return true
 		}
 	}
+	return false
 }
 
-// Parse looks at all available sources of param values (command line,
-// environment variables, configuration file) and puts together all the
-// discovered values. Once this returns it is possible to retrieve values for
-// specific params. If the --help or --example flags are set on the command line
-// their associated output is dumped to stdout os.Exit(0) will be called.
-func (f *Lever) Parse() {
-	found, remaining := f.readCLI(os.Args[1:])
+// validateCount checks that the number of values the param ended up with,
+// after all sources have been merged, is within its MinValues and MaxValues
+func (p *Param) validateCount(vals []string) error {
+	if p.MinValues > 0 && len(vals) < p.MinValues {
+		return fmt.Errorf("expected at least %d value(s), got %d", p.MinValues, len(vals))
+	} else if p.MaxValues > 0 && len(vals) > p.MaxValues {
+		return fmt.Errorf("expected at most %d value(s), got %d", p.MaxValues, len(vals))
+	}
+	return nil
+}
 
-	if help, ok := found["--help"]; ok && help[0] == "true" {
-		fmt.Fprint(os.Stdout, f.Help())
-		os.Stdout.Sync()
-		os.Exit(0)
+// ValidateConfigFile reads the config file at the given path, without looking
+// at any other source, and checks that the values in it are valid for their
+// params. If StrictConfig is set in Opts then keys which don't match any
+// expected param are considered invalid as well. Since other sources may fill
+// in missing values default values are not applied. A MultiError of
+// *ParamErrors is returned describing every problem found
+func (f *Lever) ValidateConfigFile(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", path, err)
 	}
+	defer fd.Close()
 
-	if example, ok := found["--example"]; ok && example[0] == "true" {
-		fmt.Fprint(os.Stdout, f.Example())
-		os.Stdout.Sync()
-		os.Exit(0)
+	keyed, _, err := f.decodeConfigKeys(fd, path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
 	}
 
-	foundEnv := f.readEnv(os.Environ())
-	mergeFound(found, foundEnv)
+	found, unknown := f.matchConfigKeys(keyed)
+	var errs MultiError
+	if f.o.StrictConfig {
+		errs = unknownConfigErr(unknown)
+	}
 
-	if !f.o.DisallowConfigFile {
-		foundConfig, err := f.maybeReadConfig(found)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			os.Stderr.Sync()
-			os.Exit(1)
+	for _, p := range f.sortedExpected() {
+		vals, ok := found[p.Name]
+		if !ok {
+			continue
+		}
+		if err := p.validate(vals); err != nil {
+			errs = append(errs, &ParamError{
+				Param:   p.Name,
+				Source:  SourceConfig,
+				Message: err.Error(),
+			})
 		}
-		mergeFound(found, foundConfig)
 	}
 
-	foundDef := f.defaultsAsFound()
-	mergeFound(found, foundDef)
+	return errs.err()
+}
 
-	f.found = found
-	f.remaining = remaining
+// canonicalName returns the Name of the param which the given name or alias
+// belongs to, or the given name if it doesn't belong to any
+func (f *Lever) canonicalName(name string) string {
+	if p, ok := f.expectedFull[name]; ok {
+		return p.Name
+	}
+	return name
 }
 
 // paramSingleStr returns the set value of the param as if it was only set once
 // (whether or not it actually was), along with whether or not it was actually
 // found
 func (f *Lever) paramSingleStr(name string) (string, bool) {
-	if vs, ok := f.found[name]; ok {
+	if vs, ok := f.found[f.canonicalName(name)]; ok && len(vs) > 0 {
 		return vs[0], true
 	}
 	return "", false
@@ -595,7 +3325,7 @@ func (f *Lever) ParamStr(name string) (string, bool) {
 // of the given name as strings. True is returned if the values were set by
 // either the user or the default values
 func (f *Lever) ParamStrs(name string) ([]string, bool) {
-	vs, ok := f.found[name]
+	vs, ok := f.found[f.canonicalName(name)]
 	if vs == nil {
 		vs = []string{}
 	}
@@ -639,21 +3369,412 @@ func (f *Lever) ParamInts(name string) ([]int, bool) {
 	return is, true
 }
 
+// ParamInt64 returns the value of the param of the given name as an int64, for
+// values which may not fit in an int. True is returned if the value was set by
+// either the user or a default value
+func (f *Lever) ParamInt64(name string) (int64, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return 0, false
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return i, true
+}
+
+// ParamUint returns the value of the param of the given name as a uint64. True
+// is returned if the value was set by either the user or a default value
+func (f *Lever) ParamUint(name string) (uint64, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return 0, false
+	}
+
+	u, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return u, true
+}
+
+// byteUnits maps the suffixes understood by ParamBytes to their multipliers.
+// K, M, G and T (optionally followed by B) are decimal, powers of 1000, while
+// Ki, Mi, Gi and Ti (optionally followed by B) are binary, powers of 1024.
+// Suffixes are case-insensitive
+var byteUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1e3,
+	"m":  1e6,
+	"g":  1e9,
+	"t":  1e12,
+	"ki": 1 << 10,
+	"mi": 1 << 20,
+	"gi": 1 << 30,
+	"ti": 1 << 40,
+}
+
+// parseBytes parses a size such as "512KB", "1.5GiB" or "100" into a number of
+// bytes, see byteUnits for the suffixes understood
+func parseBytes(v string) (int64, bool) {
+	v = strings.TrimSpace(v)
+	i := strings.IndexFunc(v, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(v)
+	}
+	num, unit := v[:i], strings.ToLower(strings.TrimSpace(v[i:]))
+	if len(unit) > 1 {
+		unit = strings.TrimSuffix(unit, "b")
+	}
+
+	mult, ok := byteUnits[unit]
+	if !ok || num == "" {
+		return 0, false
+	}
+
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if n > math.MaxInt64/mult {
+			return 0, false
+		}
+		return n * mult, true
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n*float64(mult) >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(n * float64(mult)), true
+}
+
+// ParamBytes returns the value of the param of the given name as a number of
+// bytes. The value may have a size suffix, for example "512KB" or "2GiB"; K, M,
+// G and T are multiples of 1000, Ki, Mi, Gi and Ti are multiples of 1024, and
+// either may be followed by a B. True is returned if the value was set by
+// either the user or a default value and could be parsed
+func (f *Lever) ParamBytes(name string) (int64, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return 0, false
+	}
+	return parseBytes(v)
+}
+
+// ParamFloat returns the value of the param of the given name as a float64.
+// True is returned if the value was set by either the user or a default value
+func (f *Lever) ParamFloat(name string) (float64, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return 0, false
+	}
+
+	fl, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return fl, true
+}
+
+// ParamFloats returns all the values of the param (if it was set multiple
+// times) of the given name as float64s. True is returned if the values were set
+// by either the user or the default values
+func (f *Lever) ParamFloats(name string) ([]float64, bool) {
+	vs, ok := f.ParamStrs(name)
+	if !ok {
+		return []float64{}, false
+	}
+
+	fls := make([]float64, len(vs))
+	for ii := range vs {
+		fl, err := strconv.ParseFloat(vs[ii], 64)
+		if err != nil {
+			return []float64{}, false
+		}
+		fls[ii] = fl
+	}
+
+	return fls, true
+}
+
+// ParamDuration returns the value of the param of the given name as a
+// time.Duration, parsed using time.ParseDuration. True is returned if the value
+// was set by either the user or a default value
+func (f *Lever) ParamDuration(name string) (time.Duration, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return d, true
+}
+
+// ParamDurations returns all the values of the param (if it was set multiple
+// times) of the given name as time.Durations. True is returned if the values
+// were set by either the user or the default values
+func (f *Lever) ParamDurations(name string) ([]time.Duration, bool) {
+	vs, ok := f.ParamStrs(name)
+	if !ok {
+		return []time.Duration{}, false
+	}
+
+	ds := make([]time.Duration, len(vs))
+	for ii := range vs {
+		d, err := time.ParseDuration(vs[ii])
+		if err != nil {
+			return []time.Duration{}, false
+		}
+		ds[ii] = d
+	}
+
+	return ds, true
+}
+
+// ParamBool returns the value of the param of the given name as a bool, parsed
+// using strconv.ParseBool, so for example "1", "t" and "true" are all true.
+// Unlike ParamFlag the value is interpreted literally, regardless of the
+// param's Default. True is returned as the second value if the value was set
+// by either the user or a default value and is a valid bool
+func (f *Lever) ParamBool(name string) (bool, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return false, false
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, false
+	}
+
+	return b, true
+}
+
+// ParamHostPort returns the value of the param of the given name split into
+// its host and port, for example "0.0.0.0:8080" gives "0.0.0.0" and 8080. A
+// value with only a port, like ":8080", gives an empty host. True is returned
+// if the value was set by either the user or a default value and is well formed
+func (f *Lever) ParamHostPort(name string) (string, int, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return "", 0, false
+	}
+
+	host, portStr, err := net.SplitHostPort(v)
+	if err != nil {
+		return "", 0, false
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return host, int(port), true
+}
+
+// ParamRawJSON returns the value of the param of the given name as a
+// json.RawMessage, without unmarshalling it, so that it can be passed along
+// untouched. True is returned if the value was set by either the user or a
+// default value and is syntactically valid JSON
+func (f *Lever) ParamRawJSON(name string) (json.RawMessage, bool) {
+	v, ok := f.paramSingleStr(name)
+	if !ok || !json.Valid([]byte(v)) {
+		return nil, false
+	}
+	return json.RawMessage(v), true
+}
+
+// keyedValues returns the values of all params, keyed by their config file
+// name, along with any values from the config file which didn't match a param
+func (f *Lever) keyedValues() map[string][]string {
+	keyed := map[string][]string{}
+	for k, vs := range f.unknownConfig {
+		keyed[k] = vs
+	}
+	for n, vs := range f.found {
+		if p, ok := f.expected[n]; ok {
+			keyed[p.configName()] = vs
+		}
+	}
+	return keyed
+}
+
+// ParamNestedMap returns all values whose config file name is of the form
+// "<prefix>.<name>.<key>", grouped by name and then key. Values are taken from
+// the expected params as well as any keys in the config file which didn't
+// match an expected param, so that nested values don't all need to be declared
+// up front. For example, given the config file:
+//
+//	plugin.foo.path: /tmp/foo
+//	plugin.foo.enabled: true
+//	plugin.bar.path: /tmp/bar
+//
+// ParamNestedMap("plugin") returns:
+//
+//	map[string]map[string]string{
+//		"foo": {"path": "/tmp/foo", "enabled": "true"},
+//		"bar": {"path": "/tmp/bar"},
+//	}
+//
+// If a key is set more than once only its first value is used
+func (f *Lever) ParamNestedMap(prefix string) map[string]map[string]string {
+	prefix = strings.TrimSuffix(prefix, ".") + "."
+	m := map[string]map[string]string{}
+	for k, vs := range f.keyedValues() {
+		if !strings.HasPrefix(k, prefix) || len(vs) == 0 {
+			continue
+		}
+		parts := strings.SplitN(k[len(prefix):], ".", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		if m[parts[0]] == nil {
+			m[parts[0]] = map[string]string{}
+		}
+		m[parts[0]][parts[1]] = vs[0]
+	}
+	return m
+}
+
+// ParamIndexedMaps is like ParamNestedMap, but for config file names of the
+// form "<prefix>.<index>.<key>" where index is a non-negative integer. The
+// grouped values are returned ordered by their index. Indices need not be
+// contiguous; missing ones are skipped, so the position of a map in the
+// returned slice won't necessarily match its index. Names whose middle segment
+// isn't an index are ignored
+func (f *Lever) ParamIndexedMaps(prefix string) []map[string]string {
+	nested := f.ParamNestedMap(prefix)
+	indices := make([]int, 0, len(nested))
+	byIndex := map[int]map[string]string{}
+	for k, m := range nested {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 {
+			continue
+		}
+		indices = append(indices, i)
+		byIndex[i] = m
+	}
+	sort.Ints(indices)
+
+	ms := make([]map[string]string, len(indices))
+	for j, i := range indices {
+		ms[j] = byIndex[i]
+	}
+	return ms
+}
+
+// Constraints returns the rules the values of the param of the given name (or
+// alias) must follow, for example for generating documentation. The zero value
+// is returned if there's no such param
+func (f *Lever) Constraints(name string) ParamConstraints {
+	p, ok := f.expectedFull[name]
+	if !ok {
+		return ParamConstraints{}
+	}
+	c := ParamConstraints{
+		MinValues: p.MinValues,
+		MaxValues: p.MaxValues,
+		Bool:      p.Flag,
+		Choices:   p.Choices,
+		Min:       p.Min,
+		Max:       p.Max,
+	}
+	if p.Required {
+		c.Required = true
+		c.RequiredIgnoresDefault = f.o.RequiredIgnoresDefault || p.Flag
+	}
+	return c
+}
+
+// ParamEnum returns the value of the param of the given name as looked up in
+// the given mapping, for example to translate a string into a constant. False is
+// returned if the param wasn't set or its value isn't in the mapping. This is a
+// function rather than a method because methods can't have type parameters
+func ParamEnum[T comparable](f *Lever, name string, mapping map[string]T) (T, bool) {
+	var zero T
+	v, ok := f.paramSingleStr(name)
+	if !ok {
+		return zero, false
+	}
+	t, ok := mapping[v]
+	if !ok {
+		return zero, false
+	}
+	return t, true
+}
+
 // ParamFlag returns the value of the param of the given name as a boolean. The
 // param need only be set with no value to take on the opposite value of its
 // Default. This almost always means that if the flag is set true is returned.
+// Values from the environment or config file, or given on the command line like
+// "--flag=false", are interpreted literally, so "true" or "1" is true and
+// "false" or "0" is false.
 func (f *Lever) ParamFlag(name string) bool {
 	v, _ := f.paramSingleStr(name)
 	p, ok := f.expectedFull[name]
 	if !ok {
 		// This is kind of weird but whatever, do something kind of sane
-		return v != ""
+		b, _ := parseFlag(v)
+		return b
 	}
-	def := p.flagDefault()
-	if v == "" || v == "false" {
-		return def
+	if v == "" {
+		return p.flagDefault()
+	}
+	b, err := parseFlag(v)
+	if err != nil {
+		return p.flagDefault()
+	}
+	return b
+}
+
+// DryRun returns whether the --dry-run flag added by DryRunFlag was set. It
+// always returns false if DryRunFlag isn't set
+func (f *Lever) DryRun() bool {
+	if !f.o.DryRunFlag {
+		return false
+	}
+	return f.ParamFlag("--dry-run")
+}
+
+// DefaultedParams returns the Names of the params, sorted, whose values from
+// the most recent parse came from their defaults, rather than being set on the
+// command line, in the environment, or in the config file
+func (f *Lever) DefaultedParams() []string {
+	var names []string
+	for _, p := range f.sortedExpected() {
+		if f.foundSource[p.Name] == SourceDefault {
+			names = append(names, p.Name)
+		}
 	}
-	return !def
+	return names
+}
+
+// ParamPrevious returns the values the param of the given name had before the
+// most recent parse, for applications which parse more than once in order to
+// reload their configuration. False is returned if there wasn't a previous
+// parse or the param wasn't set in it
+func (f *Lever) ParamPrevious(name string) ([]string, bool) {
+	vs, ok := f.previous[f.canonicalName(name)]
+	return vs, ok
+}
+
+// Source returns where the value of the param of the given name came from in
+// the most recent parse, for example SourceCLI or SourceDefault. False is
+// returned if the param wasn't set by any source or there hasn't been a parse
+func (f *Lever) Source(name string) (Source, bool) {
+	src, ok := f.foundSource[f.canonicalName(name)]
+	return src, ok
 }
 
 // ParamRest returns any command line parameters which were passed in by the
@@ -663,3 +3784,52 @@ func (f *Lever) ParamFlag(name string) bool {
 func (f *Lever) ParamRest() []string {
 	return f.remaining
 }
+
+// ParamRestUnknown returns the part of ParamRest which came before any "--"
+// parameter, i.e. the command line parameters which weren't expected
+func (f *Lever) ParamRestUnknown() []string {
+	return f.remaining[:f.restSplit]
+}
+
+// ParamRestVerbatim returns the part of ParamRest which came after a "--"
+// parameter or a TerminatesParsing param, exactly as given. When parsing with
+// ParseWithoutCLI this is all of the arguments
+func (f *Lever) ParamRestVerbatim() []string {
+	return f.remaining[f.restSplit:]
+}
+
+func equalStrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares the resolved values of this Lever with those of the other one,
+// which should have the same params added to it. For every param whose values
+// differ between the two the returned map holds the values from this Lever and
+// then the values from the other, keyed by the param's Name. A param which
+// wasn't set at all has nil values. Both Levers must have been parsed already
+func (f *Lever) Diff(other *Lever) map[string][2][]string {
+	names := map[string]bool{}
+	for n := range f.expected {
+		names[n] = true
+	}
+	for n := range other.expected {
+		names[n] = true
+	}
+
+	diff := map[string][2][]string{}
+	for n := range names {
+		a, b := f.found[n], other.found[n]
+		if !equalStrs(a, b) {
+			diff[n] = [2][]string{a, b}
+		}
+	}
+	return diff
+}
@@ -2,8 +2,16 @@ package lever
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
 	. "testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,6 +36,17 @@ func testLever(disallowConfig bool) *Lever {
 	return f
 }
 
+// testConfigFile writes the given contents to a temporary file and returns its
+// path. The caller is responsible for removing it
+func testConfigFile(t *T, contents string) string {
+	fd, err := os.CreateTemp("", "lever-test")
+	require.Nil(t, err)
+	defer fd.Close()
+	_, err = fd.WriteString(contents)
+	require.Nil(t, err)
+	return fd.Name()
+}
+
 func TestHelp(t *T) {
 	f := testLever(false)
 	assert.Equal(t, `
@@ -138,10 +157,11 @@ foo:
 
 func TestReadCLI(t *T) {
 	f := testLever(false)
-	found, remaining := f.readCLI([]string{
+	found, remaining, err := f.readCLI([]string{
 		"--bar=butts", "-c", "baz", "--buz=buz", "--buz", "buz2", "--flag1",
 		"something", "--unk=wat", "--foo",
 	})
+	require.Nil(t, err)
 
 	assert.Equal(t, map[string][]string{
 		"--bar":   []string{"butts"},
@@ -152,10 +172,11 @@ func TestReadCLI(t *T) {
 	}, found)
 	assert.Equal(t, []string{"something", "--unk=wat"}, remaining)
 
-	found, remaining = f.readCLI([]string{
+	found, remaining, err = f.readCLI([]string{
 		"--bar=butts", "-c", "baz", "--buz=buz", "--", "--buz", "buz2",
 		"--flag1", "something", "--unk=wat", "--foo",
 	})
+	require.Nil(t, err)
 
 	assert.Equal(t, map[string][]string{
 		"--bar": []string{"butts"},
@@ -214,3 +235,2484 @@ func TestReadEnv(t *T) {
 		"--foo-bar": []string{"okthen"},
 	}, f.readEnv(env))
 }
+
+func TestParamRawJSON(t *T) {
+	f := testLever(false)
+	f.found = map[string][]string{
+		"--foo": []string{`{"a":[1,2,3],"b":null}`},
+		"--bar": []string{`{"a":`},
+	}
+
+	raw, ok := f.ParamRawJSON("--foo")
+	assert.True(t, ok)
+	assert.Equal(t, json.RawMessage(`{"a":[1,2,3],"b":null}`), raw)
+
+	_, ok = f.ParamRawJSON("--bar")
+	assert.False(t, ok)
+
+	_, ok = f.ParamRawJSON("--baz")
+	assert.False(t, ok)
+}
+
+func TestParseArgsHelpExample(t *T) {
+	f := testLever(false)
+	buf := new(bytes.Buffer)
+	f.o.Output = buf
+
+	err := f.ParseArgs([]string{"--help"}, nil)
+	assert.Equal(t, ErrHelp, err)
+	assert.Equal(t, f.Help(), buf.String())
+
+	buf.Reset()
+	err = f.ParseArgs([]string{"-example"}, nil)
+	assert.Equal(t, ErrExample, err)
+	assert.Equal(t, f.Example(), buf.String())
+
+	buf.Reset()
+	err = f.ParseArgs([]string{"--foo", "bar"}, nil)
+	require.Nil(t, err)
+	assert.Empty(t, buf.String())
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+}
+
+func TestReadConfigSkipEmpty(t *T) {
+	conf := "foo:\nbuz: a\nbuz:\nbuz: b\n"
+
+	f := testLever(false)
+	found, err := f.readConfig(bytes.NewBufferString(conf))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--foo": []string{""},
+		"--buz": []string{"a", "", "b"},
+	}, found)
+
+	f.o.SkipEmptyConfigValues = true
+	found, err = f.readConfig(bytes.NewBufferString(conf))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--buz": []string{"a", "b"},
+	}, found)
+}
+
+func TestParamNestedMap(t *T) {
+	f := testLever(false)
+	f.Add(Param{Name: "--plugin.foo.enabled", Default: "false"})
+
+	conf := testConfigFile(t, `
+plugin.foo.path: /tmp/foo
+plugin.foo.sub.key: a
+plugin.bar.path: /tmp/bar
+plugin.baz: ignored
+other.foo.path: ignored
+`)
+	defer os.Remove(conf)
+
+	err := f.ParseArgs([]string{"--config", conf}, []string{
+		"TEST_APP_PLUGIN.FOO.ENABLED=true",
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, map[string]map[string]string{
+		"foo": {"path": "/tmp/foo", "sub.key": "a", "enabled": "true"},
+		"bar": {"path": "/tmp/bar"},
+	}, f.ParamNestedMap("plugin"))
+	assert.Equal(t, f.ParamNestedMap("plugin"), f.ParamNestedMap("plugin."))
+	assert.Empty(t, f.ParamNestedMap("nope"))
+}
+
+func TestExampleHeader(t *T) {
+	f := New("test-app", &Opts{
+		ExampleHeader: "# Copyright whoever\n# Licensed however",
+	})
+	f.Add(Param{Name: "--foo", Default: "bar"})
+	assert.Equal(t, `# Copyright whoever
+# Licensed however
+
+foo: bar
+
+`, f.Example())
+
+	f.o.ExampleHeader = "-"
+	assert.Equal(t, "foo: bar\n\n", f.Example())
+}
+
+func TestValidateConfigFile(t *T) {
+	f := testLever(false)
+
+	valid := testConfigFile(t, "foo: a\nbuz: a\nbuz: b\nflag1: true\nunk: ok\n")
+	defer os.Remove(valid)
+	assert.Nil(t, f.ValidateConfigFile(valid))
+
+	invalid := testConfigFile(t, "foo: a\nflag1: yes\nflag2: 0\nunk: ok\n")
+	defer os.Remove(invalid)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--flag1", Source: SourceConfig, Message: `invalid flag value "yes"`},
+	}, f.ValidateConfigFile(invalid))
+
+	f.o.StrictConfig = true
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "unk", Source: SourceConfig, Message: "unknown key"},
+		&ParamError{Param: "--flag1", Source: SourceConfig, Message: `invalid flag value "yes"`},
+	}, f.ValidateConfigFile(invalid))
+
+	err := f.ValidateConfigFile(invalid + "-nope")
+	assert.NotNil(t, err)
+
+	err = f.ParseArgs([]string{"--config", valid}, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "unk", Source: SourceConfig, Message: "unknown key"},
+	}, err)
+}
+
+func TestAddAfterParse(t *T) {
+	f := testLever(false)
+	f.Add(Param{Name: "--before"})
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.Panics(t, func() {
+		f.Add(Param{Name: "--after"})
+	})
+}
+
+func TestConfigStdin(t *T) {
+	f := testLever(false)
+	f.o.Input = bytes.NewBufferString("foo: from-stdin\nbuz: a\n")
+	require.Nil(t, f.ParseArgs([]string{"--config", "-"}, nil))
+
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "from-stdin", foo)
+	buz, _ := f.ParamStrs("--buz")
+	assert.Equal(t, []string{"a"}, buz)
+}
+
+func TestFormatError(t *T) {
+	f := testLever(false)
+	err := MultiError{
+		&ParamError{Param: "unk", Source: SourceConfig, Message: "unknown key"},
+		errors.New("something else"),
+	}
+	assert.Equal(t, err.Error(), f.formatError(err))
+
+	f.o.ErrorFormat = ErrorFormatJSON
+	assert.JSONEq(t, `[
+		{"param":"unk","source":"config","message":"unknown key"},
+		{"param":"","source":"","message":"something else"}
+	]`, f.formatError(err))
+
+	assert.JSONEq(t, `[{"param":"","source":"","message":"oh no"}]`,
+		f.formatError(errors.New("oh no")))
+}
+
+func TestParamIndexedMaps(t *T) {
+	f := testLever(false)
+	conf := testConfigFile(t, `
+server.0.host: a.example.com
+server.0.port: 80
+server.5.host: b.example.com
+server.10.host: c.example.com
+server.10.port: 8080
+server.x.host: ignored
+`)
+	defer os.Remove(conf)
+	require.Nil(t, f.ParseArgs([]string{"--config", conf}, nil))
+
+	assert.Equal(t, []map[string]string{
+		{"host": "a.example.com", "port": "80"},
+		{"host": "b.example.com"},
+		{"host": "c.example.com", "port": "8080"},
+	}, f.ParamIndexedMaps("server"))
+	assert.Empty(t, f.ParamIndexedMaps("client"))
+}
+
+func TestReadCLIStrictFlags(t *T) {
+	f := testLever(false)
+	args := []string{"--flag1=false", "--flag2", "--foo=bar"}
+
+	found, _, err := f.readCLI(args)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"false"}, found["--flag1"])
+
+	for arg, expected := range map[string]bool{
+		"--flag1=0": false, "--flag1=": false, "--flag1=1": true, "--flag1=true": true,
+	} {
+		require.Nil(t, f.ParseArgs([]string{arg}, nil))
+		assert.Equal(t, expected, f.ParamFlag("--flag1"), arg)
+	}
+
+	f.o.StrictFlags = true
+	found, _, err = f.readCLI(args)
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "--flag1",
+		Source:  SourceCLI,
+		Message: `flag does not take a value, but was given "--flag1=false"`,
+	}}, err)
+	assert.Equal(t, []string{"true"}, found["--flag2"])
+	assert.Equal(t, []string{"bar"}, found["--foo"])
+
+	assert.Equal(t, err, f.ParseArgs(args, nil))
+}
+
+func TestDiff(t *T) {
+	current, proposed := testLever(false), testLever(false)
+	require.Nil(t, current.ParseArgs(
+		[]string{"--foo", "a", "--buz", "x", "--bar", "same"}, nil,
+	))
+	require.Nil(t, proposed.ParseArgs(
+		[]string{"--foo", "b", "--flag1", "--bar", "same"}, nil,
+	))
+
+	assert.Equal(t, map[string][2][]string{
+		"--foo":   {{"a"}, {"b"}},
+		"--buz":   {{"x"}, {"a", "b", "c"}},
+		"--flag1": {nil, {"true"}},
+	}, current.Diff(proposed))
+	assert.Empty(t, current.Diff(current))
+}
+
+func TestHelpNamePrefix(t *T) {
+	f := New("test-app", &Opts{DisallowConfigFile: true, HelpNamePrefix: "-"})
+	f.Add(Param{Name: "--foo", Aliases: []string{"-f"}})
+	assert.Equal(t, `
+	-foo, -f
+	-help, -h (flag)
+		Print this help message
+
+`, f.Help())
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", "bar"}, nil))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+}
+
+func TestMinMaxValues(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--replica", MinValues: 1, MaxValues: 3})
+
+	err := f.ParseArgs(nil, nil)
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "--replica",
+		Message: "expected at least 1 value(s), got 0",
+	}}, err)
+
+	args := []string{"--replica", "a", "--replica", "b", "--replica", "c"}
+	require.Nil(t, f.ParseArgs(args, nil))
+	replicas, _ := f.ParamStrs("--replica")
+	assert.Equal(t, []string{"a", "b", "c"}, replicas)
+
+	err = f.ParseArgs(append(args, "--replica", "d"), nil)
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "--replica",
+		Source:  SourceCLI,
+		Message: "expected at most 3 value(s), got 4",
+	}}, err)
+}
+
+func TestLenientFlagValues(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--debug", Flag: true})
+
+	// Unlike ValidateConfigFile, parsing doesn't reject flag values it can't
+	// interpret
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_DEBUG=yes"}))
+	v, ok := f.ParamStr("--debug")
+	assert.True(t, ok)
+	assert.Equal(t, "yes", v)
+}
+
+func TestReadConfigEscapes(t *T) {
+	f := testLever(false)
+	found, err := f.readConfig(bytes.NewBufferString(
+		"foo: \\ value\\ \t\n" +
+			"bar:   \\ \\ \n" +
+			"baz: C:\\\\dir\\name \\\\\n" +
+			"buz: Å\n",
+	))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--foo": []string{" value "},
+		"--bar": []string{"  "},
+		"--baz": []string{`C:\dir\name \`},
+		"--buz": []string{"Å"},
+	}, found)
+}
+
+func TestStrictEnv(t *T) {
+	f := testLever(false)
+	f.o.StrictEnv = true
+	require.Nil(t, f.ParseArgs(nil, []string{
+		"TEST_APP_FOO=foo",
+		"HOME=whatever",
+		"OTHER_APP_PROT=80",
+	}))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "foo", foo)
+
+	err := f.ParseArgs(nil, []string{"TEST_APP_FOO=foo", "TEST_APP_PROT=80"})
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "TEST_APP_PROT",
+		Source:  SourceEnv,
+		Message: "unknown environment variable",
+	}}, err)
+
+	// A DefaultEnv variable is known, even when it has the app's prefix
+	f = testLever(false)
+	f.o.StrictEnv = true
+	f.Add(Param{Name: "--region", DefaultEnv: "TEST_APP_DEFAULT_REGION"})
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_DEFAULT_REGION=eu"}))
+	region, _ := f.ParamStr("--region")
+	assert.Equal(t, "eu", region)
+}
+
+func TestSource(t *T) {
+	path := testConfigFile(t, "baz: c\n")
+	defer os.Remove(path)
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo", Aliases: []string{"-f"}})
+	f.Add(Param{Name: "--bar"})
+	f.Add(Param{Name: "--baz"})
+	f.Add(Param{Name: "--buz", Default: "d"})
+	f.Add(Param{Name: "--unset"})
+
+	_, ok := f.Source("--foo")
+	assert.False(t, ok)
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--foo", "a", "--config", path},
+		[]string{"TEST_APP_FOO=x", "TEST_APP_BAR=b"},
+	))
+	for name, expect := range map[string]Source{
+		"--foo": SourceCLI,
+		"-f":    SourceCLI,
+		"--bar": SourceEnv,
+		"--baz": SourceConfig,
+		"--buz": SourceDefault,
+	} {
+		src, ok := f.Source(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expect, src, name)
+	}
+	_, ok = f.Source("--unset")
+	assert.False(t, ok)
+	_, ok = f.Source("--nope")
+	assert.False(t, ok)
+}
+
+func TestParamPrevious(t *T) {
+	f := testLever(false)
+	require.Nil(t, f.ParseArgs([]string{"--foo", "a", "--bar", "b"}, nil))
+	_, ok := f.ParamPrevious("--foo")
+	assert.False(t, ok)
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", "a", "--bar", "c"}, nil))
+	prev, ok := f.ParamPrevious("--foo")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a"}, prev)
+	cur, _ := f.ParamStrs("--foo")
+	assert.Equal(t, prev, cur)
+
+	prev, ok = f.ParamPrevious("--bar")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"b"}, prev)
+	cur, _ = f.ParamStrs("--bar")
+	assert.Equal(t, []string{"c"}, cur)
+
+	_, ok = f.ParamPrevious("--flag1")
+	assert.False(t, ok)
+}
+
+func TestMultiMerge(t *T) {
+	conf := testConfigFile(t, "foo: conf1\nfoo: conf2\n")
+	defer os.Remove(conf)
+	args := []string{"--config", conf, "--foo", "cli"}
+	env := []string{"TEST_APP_FOO=env"}
+
+	for _, test := range []struct {
+		mode     MultiMerge
+		expected []string
+	}{
+		{MultiMergeReplace, []string{"cli"}},
+		{MultiMergeAppend, []string{"conf1", "conf2", "env", "cli"}},
+		{MultiMergePrepend, []string{"cli", "env", "conf1", "conf2"}},
+	} {
+		f := New("test-app", nil)
+		f.Add(Param{
+			Name:         "--foo",
+			DefaultMulti: []string{"def"},
+			MultiMerge:   test.mode,
+		})
+		require.Nil(t, f.ParseArgs(args, env))
+		foo, _ := f.ParamStrs("--foo")
+		assert.Equal(t, test.expected, foo)
+
+		require.Nil(t, f.ParseArgs(nil, nil))
+		foo, _ = f.ParamStrs("--foo")
+		assert.Equal(t, []string{"def"}, foo)
+	}
+}
+
+func TestResolve(t *T) {
+	f := testLever(false)
+	f.o.AllowAddAfterParse = true
+	conf := testConfigFile(t, "late-conf: from-conf\n")
+	defer os.Remove(conf)
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--config", conf, "--late-cli", "from-cli", "--foo", "foo"},
+		[]string{"TEST_APP_LATE_ENV=from-env"},
+	))
+	r, err := f.ParseResult(f.args, f.environ)
+	require.Nil(t, err)
+
+	f.Add(Param{Name: "--late-cli"})
+	f.Add(Param{Name: "--late-env"})
+	f.Add(Param{Name: "--late-conf"})
+	f.Add(Param{Name: "--late-def", Default: "from-def"})
+	f.Add(Param{Name: "--late-unset"})
+	f.Add(Param{Name: "--late-alias", Aliases: []string{"-l"}, Default: "from-alias"})
+
+	_, ok := f.ParamStr("--late-cli")
+	assert.False(t, ok)
+
+	for _, test := range []struct {
+		name, val string
+		src       Source
+	}{
+		{"--late-cli", "from-cli", SourceCLI},
+		{"--late-env", "from-env", SourceEnv},
+		{"--late-conf", "from-conf", SourceConfig},
+		{"--late-def", "from-def", SourceDefault},
+		{"-l", "from-alias", SourceDefault},
+	} {
+		vals, src, ok := f.Resolve(test.name)
+		assert.True(t, ok)
+		assert.Equal(t, []string{test.val}, vals)
+		assert.Equal(t, test.src, src)
+		v, _ := f.ParamStr(test.name)
+		assert.Equal(t, test.val, v)
+	}
+
+	_, _, ok = f.Resolve("--late-unset")
+	assert.False(t, ok)
+
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "foo", foo)
+
+	// Results from before resolving aren't affected
+	_, ok = r.Str("--late-cli")
+	assert.False(t, ok)
+}
+
+func TestMaxConfigBytes(t *T) {
+	conf := testConfigFile(t, "foo: 0123456789\n")
+	defer os.Remove(conf)
+
+	f := testLever(false)
+	f.o.MaxConfigBytes = 16
+	require.Nil(t, f.ParseArgs([]string{"--config", conf}, nil))
+
+	f.o.MaxConfigBytes = 15
+	err := f.ParseArgs([]string{"--config", conf}, nil)
+	assert.Equal(t,
+		fmt.Sprintf("error reading %s: exceeds maximum size of 15 bytes", conf),
+		err.Error())
+}
+
+func TestDefaultEnv(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--region", DefaultEnv: "AWS_REGION", Default: "us-east-1"})
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	region, _ := f.ParamStr("--region")
+	assert.Equal(t, "us-east-1", region)
+
+	require.Nil(t, f.ParseArgs(nil, []string{"AWS_REGION=eu-west-1"}))
+	region, _ = f.ParamStr("--region")
+	assert.Equal(t, "eu-west-1", region)
+
+	require.Nil(t, f.ParseArgs(nil, []string{
+		"AWS_REGION=eu-west-1", "TEST_APP_REGION=ap-south-1",
+	}))
+	region, _ = f.ParamStr("--region")
+	assert.Equal(t, "ap-south-1", region)
+}
+
+func TestOwnDumpEnvParam(t *T) {
+	f := New("test-app", &Opts{
+		DisallowConfigFile: true,
+		Providers:          []Provider{mapProvider{"dump-env": {"true"}}},
+	})
+	f.Add(Param{Name: "--dump-env", Flag: true})
+	f.Add(Param{Name: "--example", Flag: true})
+
+	// Without DumpEnvFlag or a config file these are ordinary params
+	require.Nil(t, f.ParseArgs([]string{"--example"}, nil))
+	assert.True(t, f.ParamFlag("--dump-env"))
+	assert.True(t, f.ParamFlag("--example"))
+	assert.Equal(t,
+		"export TEST_APP_DUMP_ENV='true'\nexport TEST_APP_EXAMPLE='true'\n",
+		f.EnvScript(),
+	)
+}
+
+func TestDumpEnv(t *T) {
+	f := New("test-app", &Opts{DisallowConfigFile: true, DumpEnvFlag: true})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--foo-bar"})
+	buf := new(bytes.Buffer)
+	f.o.Output = buf
+
+	env := []string{"TEST_APP_FOO_BAR=baz", "HOME=whatever"}
+	err := f.ParseArgs([]string{"--dump-env"}, env)
+	assert.Equal(t, ErrDumpEnv, err)
+	assert.Equal(t, `TEST_APP_FOO (unset)
+TEST_APP_FOO_BAR=baz
+`, buf.String())
+	assert.Equal(t, buf.String(), f.DumpEnv(env))
+}
+
+func TestHelpAliases(t *T) {
+	f := New("test-app", &Opts{
+		DisallowConfigFile: true,
+		AliasSeparator:     " | ",
+		ShortAliasesFirst:  true,
+	})
+	f.Add(Param{Name: "--verbose", Aliases: []string{"--loud", "-v", "-noisy", "-V"}})
+	assert.Equal(t, `
+	--help | -h | -help (flag)
+		Print this help message
+
+	--verbose | -v | -V | --loud | -noisy
+`, f.Help())
+
+	require.Nil(t, f.ParseArgs([]string{"-noisy", "x"}, nil))
+	v, _ := f.ParamStr("--verbose")
+	assert.Equal(t, "x", v)
+}
+
+func TestParamFlagValues(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--a", Flag: true})
+	f.Add(Param{Name: "--b", Flag: true})
+	f.Add(Param{Name: "--c", Flag: true})
+	f.Add(Param{Name: "--d", Flag: true})
+	f.Add(Param{Name: "--on", Flag: true, Default: "true"})
+	conf := testConfigFile(t, "a: 0\nb: 1\nc: false\nd: true\n")
+	defer os.Remove(conf)
+
+	require.Nil(t, f.ParseArgs([]string{"--config", conf}, nil))
+	assert.False(t, f.ParamFlag("--a"))
+	assert.True(t, f.ParamFlag("--b"))
+	assert.False(t, f.ParamFlag("--c"))
+	assert.True(t, f.ParamFlag("--d"))
+	assert.True(t, f.ParamFlag("--on"))
+
+	require.Nil(t, f.ParseArgs([]string{"--a", "--on"}, nil))
+	assert.True(t, f.ParamFlag("--a"))
+	assert.False(t, f.ParamFlag("--on"))
+}
+
+func TestParams(t *T) {
+	f := New("test-app", &Opts{DryRunFlag: true})
+	f.Add(Param{Name: "--foo", Description: "Foo"})
+	f.Add(Param{Name: "--bar", Aliases: []string{"-b"}})
+
+	ps := f.Params(false)
+	require.Len(t, ps, 2)
+	assert.Equal(t, "--bar", ps[0].Name)
+	assert.Equal(t, []string{"-b"}, ps[0].Aliases)
+	assert.Equal(t, "--foo", ps[1].Name)
+	assert.Equal(t, "Foo", ps[1].Description)
+	assert.Equal(t, []string{"--bar", "--foo"}, f.ParamNames(false))
+	assert.Equal(t,
+		[]string{"--bar", "--config", "--dry-run", "--example", "--foo", "--help"},
+		f.ParamNames(true),
+	)
+
+	// The returned params are copies
+	ps[0].Name = "--wat"
+	assert.Equal(t, []string{"--bar", "--foo"}, f.ParamNames(false))
+
+	f = New("test-app", &Opts{DisallowConfigFile: true})
+	f.Add(Param{Name: "--config"})
+	assert.Equal(t, []string{"--config"}, f.ParamNames(false))
+	assert.Equal(t, []string{"--config", "--help"}, f.ParamNames(true))
+}
+
+func TestCheckDocumented(t *T) {
+	f := New("test-app", nil)
+	assert.Nil(t, f.CheckDocumented())
+
+	f.Add(Param{Name: "--foo", Description: "Foo"})
+	f.Add(Param{Name: "--bar"})
+	f.Add(Param{Name: "--baz", Description: "  "})
+	f.Add(Param{Name: "--buz", Flag: true, Description: "Buz"})
+	assert.Equal(t, []string{"--bar", "--baz"}, f.CheckDocumented())
+}
+
+func TestAddAll(t *T) {
+	f := New("test-app", nil)
+	err := f.AddAll([]Param{
+		{Name: "--foo", Aliases: []string{"-f"}},
+		{Name: "--bad name"},
+		{Name: "--bar", Aliases: []string{"-f"}},
+		{},
+		{Name: "--baz=1"},
+		{Name: "--help"},
+		{Name: "--buz"},
+	})
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--bad name", Message: `"--bad name" must not contain whitespace`},
+		&ParamError{Param: "--bar", Message: `"-f" is already used by --foo`},
+		&ParamError{Param: "params[3]", Message: "name must not be empty"},
+		&ParamError{Param: "--baz=1", Message: `"--baz=1" must not contain : or =`},
+		&ParamError{Param: "--help", Message: `"--help" is already used by --help`},
+	}, err)
+
+	for _, name := range []string{"--foo", "--buz"} {
+		_, ok := f.expected[name]
+		assert.True(t, ok, name)
+	}
+	for _, name := range []string{"--bad name", "--bar", "--baz=1"} {
+		_, ok := f.expected[name]
+		assert.False(t, ok, name)
+	}
+}
+
+func TestRequired(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--db-url", Required: true})
+	f.Add(Param{Name: "--region", Required: true, Default: "placeholder"})
+
+	err := f.ParseArgs(nil, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--db-url", Message: "required"},
+	}, err)
+	require.Nil(t, f.ParseArgs([]string{"--db-url", "x"}, nil))
+
+	f.o.RequiredIgnoresDefault = true
+	err = f.ParseArgs([]string{"--db-url", "x"}, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--region", Message: "required"},
+	}, err)
+	require.Nil(t, f.ParseArgs(
+		[]string{"--db-url", "x"}, []string{"TEST_APP_REGION=eu"},
+	))
+}
+
+func TestRequiredFlag(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--debug", Required: true, Flag: true})
+	f.Add(Param{Name: "--tls", Required: true, Flag: true, Default: "true"})
+
+	err := f.ParseArgs(nil, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--debug", Message: "required"},
+		&ParamError{Param: "--tls", Message: "required"},
+	}, err)
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--debug"}, []string{"TEST_APP_TLS=true"},
+	))
+	assert.True(t, f.ParamFlag("--debug"))
+	assert.True(t, f.ParamFlag("--tls"))
+
+	require.Nil(t, f.ParseArgs(
+		nil, []string{"TEST_APP_DEBUG=false", "TEST_APP_TLS=false"},
+	))
+	assert.False(t, f.ParamFlag("--debug"))
+	assert.False(t, f.ParamFlag("--tls"))
+
+	require.Nil(t, f.ParseArgs([]string{"--debug=false", "--tls=false"}, nil))
+	assert.False(t, f.ParamFlag("--debug"))
+	assert.False(t, f.ParamFlag("--tls"))
+}
+
+func TestRequiredParseErr(t *T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"test-app"}
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--db-url", Required: true})
+	f.Add(Param{Name: "--api-key", Required: true})
+
+	err := f.ParseErr()
+	require.NotNil(t, err)
+	assert.Equal(t, "--api-key: required; --db-url: required", err.Error())
+}
+
+func TestParseWithoutCLI(t *T) {
+	conf := testConfigFile(t, "bar: from-conf\nbaz: from-conf\n")
+	defer os.Remove(conf)
+
+	for k, v := range map[string]string{
+		"TEST_APP_CONFIG": conf,
+		"TEST_APP_FOO":    "from-env",
+		"TEST_APP_BAR":    "from-env",
+	} {
+		require.Nil(t, os.Setenv(k, v))
+		defer os.Unsetenv(k)
+	}
+
+	f := testLever(false)
+	args := []string{"--foo", "from-cli", "--help", "pos"}
+	require.Nil(t, f.ParseWithoutCLI(args))
+
+	assert.Equal(t, args, f.ParamRest())
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "from-env", foo)
+	bar, _ := f.ParamStr("--bar")
+	assert.Equal(t, "from-env", bar)
+	baz, _ := f.ParamStr("--baz")
+	assert.Equal(t, "from-conf", baz)
+	buz, _ := f.ParamStrs("--buz")
+	assert.Equal(t, []string{"a", "b", "c"}, buz)
+
+	f.Override("--foo", "from-cli")
+	f.Override("-d", "x", "y")
+	foo, _ = f.ParamStr("--foo")
+	assert.Equal(t, "from-cli", foo)
+	buz, _ = f.ParamStrs("--buz")
+	assert.Equal(t, []string{"x", "y"}, buz)
+}
+
+func TestAdjustFlags(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--verbosity", Default: "2"})
+	f.Add(Param{Name: "--verbose", Aliases: []string{"-v"}, Flag: true, IncrementFor: "--verbosity"})
+	f.Add(Param{Name: "--quiet", Aliases: []string{"-q"}, Flag: true, DecrementFor: "--verbosity"})
+
+	for _, test := range []struct {
+		args     []string
+		env      []string
+		expected int
+	}{
+		{nil, nil, 2},
+		{[]string{"-vv", "-q"}, nil, 3},
+		{[]string{"-v", "--verbose", "-vvv"}, nil, 7},
+		{[]string{"-qq", "-q"}, nil, -1},
+		{[]string{"-v", "-q"}, nil, 2},
+		{[]string{"-v"}, []string{"TEST_APP_VERBOSITY=5"}, 6},
+	} {
+		require.Nil(t, f.ParseArgs(test.args, test.env))
+		v, ok := f.ParamInt("--verbosity")
+		assert.True(t, ok)
+		assert.Equal(t, test.expected, v, "%v", test.args)
+	}
+
+	require.Nil(t, f.ParseArgs([]string{"-vx"}, nil))
+	assert.Equal(t, []string{"-vx"}, f.ParamRest())
+
+	// A positional arg made of a repeated flag character isn't that flag
+	require.Nil(t, f.ParseArgs([]string{"vvv", "run"}, nil))
+	assert.Equal(t, []string{"vvv", "run"}, f.ParamRest())
+	v, _ := f.ParamInt("--verbosity")
+	assert.Equal(t, 2, v)
+}
+
+func TestParamByAlias(t *T) {
+	f := testLever(false)
+	require.Nil(t, f.ParseArgs([]string{"--bar", "bar", "-d", "x", "-d", "y"}, nil))
+
+	for _, name := range []string{"--bar", "-b"} {
+		bar, ok := f.ParamStr(name)
+		assert.True(t, ok)
+		assert.Equal(t, "bar", bar)
+	}
+	for _, name := range []string{"--buz", "-d"} {
+		buz, ok := f.ParamStrs(name)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"x", "y"}, buz)
+	}
+
+	byz, ok := f.ParamStr("--byz")
+	assert.False(t, ok)
+	assert.Equal(t, "", byz)
+}
+
+func TestConfigCommentPrefixes(t *T) {
+	conf := "; semicolon\n  // slashes\nfoo: a\n"
+
+	f := testLever(false)
+	_, err := f.readConfig(bytes.NewBufferString(conf))
+	assert.NotNil(t, err)
+
+	f.o.ConfigCommentPrefixes = []string{";", "//"}
+	found, err := f.readConfig(bytes.NewBufferString(conf + "bar: b\n"))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--foo": []string{"a"},
+		"--bar": []string{"b"},
+	}, found)
+
+	// # is always a comment prefix, so that Example() can be read back in
+	found, err = f.readConfig(bytes.NewBufferString(f.Example()))
+	require.Nil(t, err)
+	assert.Equal(t, "wat", found["--baz"][0])
+}
+
+func TestConfigEndOfLineComments(t *T) {
+	f := testLever(false)
+	f.o.ConfigCommentPrefixes = []string{";", "//"}
+	found, err := f.readConfig(bytes.NewBufferString(
+		"foo: bar ; comment\n" +
+			"bar: a;b //comment\n" +
+			"baz: http://x#y # comment\n" +
+			"buz: kept\\ ; escaped\n" +
+			"buz:\tc\t# tabbed\n",
+	))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--foo": []string{"bar"},
+		"--bar": []string{"a;b"},
+		"--baz": []string{"http://x#y"},
+		"--buz": []string{"kept ; escaped", "c"},
+	}, found)
+
+	// A comment can't begin until the value has
+	found, err = f.readConfig(bytes.NewBufferString(
+		"foo: #fff\nbar:  //host/x ; comment\n",
+	))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"#fff"}, found["--foo"])
+	assert.Equal(t, []string{"//host/x"}, found["--bar"])
+
+	// Values written by Dump aren't cut short when read back in
+	v := " a #b ;c "
+	escaped := escapeConfigValue(v, f.commentPrefixes())
+	found, err = f.readConfig(bytes.NewBufferString("foo: " + escaped + "\n"))
+	require.Nil(t, err)
+	assert.Equal(t, []string{v}, found["--foo"])
+
+	// Nor are values which start with a comment prefix
+	path := testConfigFile(t, "")
+	defer os.Remove(path)
+	require.Nil(t, f.ParseArgs([]string{"--foo", "#fff", "--bar", "// x"}, nil))
+	fd, err := os.Create(path)
+	require.Nil(t, err)
+	require.Nil(t, f.Dump(fd))
+	require.Nil(t, fd.Close())
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "#fff", foo)
+	bar, _ := f.ParamStr("--bar")
+	assert.Equal(t, "// x", bar)
+}
+
+func TestConfigSearch(t *T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"test-app"}
+
+	first := testConfigFile(t, "foo: first\n")
+	defer os.Remove(first)
+	second := testConfigFile(t, "foo: second\n")
+	defer os.Remove(second)
+	missing := first + "-missing"
+
+	f := testLever(false)
+	require.Nil(t, f.ParseWithConfigSearch([]string{first, second}))
+	assert.Equal(t, first, f.ConfigFilePath())
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "first", foo)
+
+	require.Nil(t, f.ParseWithConfigSearch([]string{missing, second}))
+	assert.Equal(t, second, f.ConfigFilePath())
+	foo, _ = f.ParamStr("--foo")
+	assert.Equal(t, "second", foo)
+
+	// An explicitly given config file takes precedence
+	os.Args = []string{"test-app", "--config", first}
+	require.Nil(t, f.ParseWithConfigSearch([]string{missing, second}))
+	assert.Equal(t, first, f.ConfigFilePath())
+
+	os.Args = []string{"test-app"}
+	err := f.ParseWithConfigSearch([]string{missing, missing + "2"})
+	assert.Equal(t, fmt.Sprintf(
+		"no config file found, searched: %s, %s", missing, missing+"2",
+	), err.Error())
+
+	f.o.AllowMissingConfigFile = true
+	require.Nil(t, f.ParseWithConfigSearch([]string{missing, missing + "2"}))
+	assert.Equal(t, "", f.ConfigFilePath())
+	_, ok := f.ParamStr("--foo")
+	assert.False(t, ok)
+}
+
+func TestExampleRequired(t *T) {
+	f := New("test-app", &Opts{ExampleHeader: "-"})
+	f.Add(Param{Name: "--db-url", Description: "Database to use", Required: true})
+	f.Add(Param{Name: "--region", Required: true, Default: "us-east-1"})
+	f.Add(Param{Name: "--timeout", Default: "5s"})
+	assert.Equal(t, `# Database to use
+# required
+# db-url:
+
+region: us-east-1
+
+timeout: 5s
+
+`, f.Example())
+}
+
+func TestEnvNameFunc(t *T) {
+	f := testLever(false)
+	f.Add(Param{Name: "--foo-bar"})
+	f.o.EnvNameFunc = func(appName string, p *Param) string {
+		return appName + "." + p.configName()
+	}
+
+	assert.Equal(t, map[string][]string{
+		"--bar":     []string{"bar"},
+		"--foo-bar": []string{"okthen"},
+	}, f.readEnv([]string{
+		"test-app.bar=bar",
+		"TEST_APP_FLAG1=true",
+		"test-app.foo-bar=okthen",
+	}))
+	assert.Equal(t, "TEST_APP_FOO_BAR", DefaultEnvName("test-app", f.expected["--foo-bar"]))
+}
+
+func TestEnvNames(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--db-url", EnvNames: []string{"NEW_DB_URL", "OLD_DATABASE_URL"}})
+
+	for _, test := range []struct {
+		env      []string
+		expected string
+	}{
+		{[]string{"OLD_DATABASE_URL=old", "NEW_DB_URL=new"}, "new"},
+		{[]string{"OLD_DATABASE_URL=old"}, "old"},
+		{[]string{"TEST_APP_DB_URL=derived"}, ""},
+	} {
+		require.Nil(t, f.ParseArgs(nil, test.env))
+		v, _ := f.ParamStr("--db-url")
+		assert.Equal(t, test.expected, v)
+	}
+
+	assert.Contains(t,
+		f.DumpEnv([]string{"OLD_DATABASE_URL=old"}), "NEW_DB_URL (unset)\nOLD_DATABASE_URL=old\n")
+}
+
+func TestEnvNamesDeprecated(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{
+		Name:       "--db-url",
+		EnvNames:   []string{"NEW_DB_URL", "OLD_DATABASE_URL"},
+		Deprecated: "use --database",
+	})
+
+	require.Nil(t, f.ParseArgs(nil, []string{"OLD_DATABASE_URL=old", "NEW_DB_URL=new"}))
+	assert.Equal(t, []string{"--db-url is deprecated: use --database"}, f.Warnings())
+
+	require.Nil(t, f.ParseArgs(nil, []string{"OLD_DATABASE_URL=old"}))
+	assert.Equal(t, []string{
+		"--db-url is deprecated, set by fallback environment variable OLD_DATABASE_URL: use --database",
+	}, f.Warnings())
+}
+
+func TestEnvScript(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar", Default: "it's a bar"})
+	f.Add(Param{Name: "--baz", Flag: true})
+	f.Add(Param{Name: "--buz", DefaultMulti: []string{"a", "b"}})
+	f.Add(Param{Name: "--unset"})
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", `a "b" $c`, "--baz"}, nil))
+	expected := "export TEST_APP_BAR='it'\\''s a bar'\n" +
+		"export TEST_APP_BAZ='true'\n" +
+		"export TEST_APP_FOO='a \"b\" $c'\n"
+	assert.Equal(t, expected, f.EnvScript())
+}
+
+func TestExampleDuration(t *T) {
+	f := New("test-app", &Opts{ExampleHeader: "-"})
+	f.Add(Param{Name: "--timeout", Description: "How long to wait", Default: "90s", Type: ParamTypeDuration})
+	f.Add(Param{Name: "--max-body", Default: "10MB", Type: ParamTypeSize})
+	assert.Equal(t, `# (size, e.g. 512KB, 10MB)
+max-body: 10MB
+
+# How long to wait
+# (duration, e.g. 30s, 1m)
+timeout: 1m30s
+
+`, f.Example())
+}
+
+func TestDeprecated(t *T) {
+	f := New("test-app", &Opts{ExampleHeader: "-"})
+	f.Add(Param{Name: "--new", Description: "The new way"})
+	f.Add(Param{Name: "--old", Description: "The old way", Default: "x", Deprecated: "use --new"})
+
+	assert.Contains(t, f.Help(), `
+	--new
+		The new way
+
+	--old (deprecated: use --new)
+		The old way
+		Default: x
+
+`)
+
+	assert.Equal(t, `# The new way
+new: 
+
+# The old way
+# deprecated: use --new
+old: x
+
+`, f.Example())
+
+	f.o.HideDeprecatedInExample = true
+	assert.Equal(t, "# The new way\nnew: \n\n", f.Example())
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.Empty(t, f.Warnings())
+	require.Nil(t, f.ParseArgs([]string{"--old", "y"}, nil))
+	assert.Equal(t, []string{"--old is deprecated: use --new"}, f.Warnings())
+}
+
+func TestLenientConfig(t *T) {
+	path := testConfigFile(t, "foo: a\nthis is not valid\nbar: b\n")
+	defer os.Remove(path)
+	args := []string{"--config", path}
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+	assert.NotNil(t, f.ParseArgs(args, nil))
+
+	f = New("test-app", &Opts{LenientConfig: true})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+	require.Nil(t, f.ParseArgs(args, nil))
+	foo, _ := f.ParamStr("--foo")
+	bar, _ := f.ParamStr("--bar")
+	assert.Equal(t, "a", foo)
+	assert.Equal(t, "b", bar)
+	assert.Equal(t, []string{path + `: could not parse line: "this is not valid"`}, f.Warnings())
+}
+
+func TestParamEnum(t *T) {
+	type logLevel int
+	const (
+		levelDebug logLevel = iota
+		levelInfo
+		levelWarn
+	)
+	levels := map[string]logLevel{
+		"debug": levelDebug,
+		"info":  levelInfo,
+		"warn":  levelWarn,
+	}
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--log-level", Default: "info"})
+	f.Add(Param{Name: "--other"})
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	l, ok := ParamEnum(f, "--log-level", levels)
+	assert.True(t, ok)
+	assert.Equal(t, levelInfo, l)
+
+	_, ok = ParamEnum(f, "--other", levels)
+	assert.False(t, ok)
+
+	require.Nil(t, f.ParseArgs([]string{"--log-level", "warn"}, nil))
+	l, ok = ParamEnum(f, "--log-level", levels)
+	assert.True(t, ok)
+	assert.Equal(t, levelWarn, l)
+
+	require.Nil(t, f.ParseArgs([]string{"--log-level", "loud"}, nil))
+	_, ok = ParamEnum(f, "--log-level", levels)
+	assert.False(t, ok)
+}
+
+func TestCustomBuiltinFlagNames(t *T) {
+	buf := new(bytes.Buffer)
+	f := New("test-app", &Opts{
+		Output:             buf,
+		HelpFlagName:       "--usage",
+		HelpFlagAliases:    []string{"-u"},
+		ExampleFlagName:    "--sample-config",
+		ExampleFlagAliases: []string{},
+		ConfigFlagName:     "--conf",
+	})
+	f.Add(Param{Name: "--help", Flag: true, Description: "Get help from a human"})
+	f.Add(Param{Name: "--foo", Default: "foo"})
+
+	require.Nil(t, f.ParseArgs([]string{"--help"}, nil))
+	assert.True(t, f.ParamFlag("--help"))
+	assert.Empty(t, buf.String())
+
+	assert.Equal(t, ErrHelp, f.ParseArgs([]string{"-u"}, nil))
+	assert.Contains(t, buf.String(), "--usage")
+	assert.Contains(t, buf.String(), "Get help from a human")
+
+	buf.Reset()
+	assert.Equal(t, ErrExample, f.ParseArgs([]string{"--sample-config"}, nil))
+	assert.Contains(t, buf.String(), "foo: foo\n")
+	require.Nil(t, f.ParseArgs([]string{"--example"}, nil))
+	assert.Equal(t, []string{"--example"}, f.ParamRest())
+
+	path := testConfigFile(t, "foo: bar\n")
+	defer os.Remove(path)
+	require.Nil(t, f.ParseArgs([]string{"-conf", path}, nil))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+	assert.Equal(t, path, f.ConfigFilePath())
+}
+
+func TestCustomBuiltinFlagDefaultAliases(t *T) {
+	buf := new(bytes.Buffer)
+	f := New("test-app", &Opts{
+		Output:          buf,
+		HelpFlagName:    "--usage",
+		ExampleFlagName: "--sample-config",
+	})
+	f.Add(Param{Name: "--help", Aliases: []string{"-h"}, Flag: true})
+
+	require.Nil(t, f.ParseArgs([]string{"-h"}, nil))
+	assert.True(t, f.ParamFlag("--help"))
+	assert.Equal(t, ErrHelp, f.ParseArgs([]string{"-usage"}, nil))
+
+	buf.Reset()
+	assert.Equal(t, ErrExample, f.ParseArgs([]string{"-sample-config"}, nil))
+	require.Nil(t, f.ParseArgs([]string{"-example"}, nil))
+	assert.Equal(t, []string{"-example"}, f.ParamRest())
+}
+
+func TestMultiDefaultFormat(t *T) {
+	f := New("test-app", &Opts{
+		MultiDefaultFormat: func(vs []string) string {
+			return strings.Join(vs, ", ")
+		},
+	})
+	f.Add(Param{Name: "--buz", DefaultMulti: []string{"a", "b", "c"}})
+	assert.Contains(t, f.Help(), "\t--buz\n\t\tDefault: a, b, c\n")
+}
+
+func TestConfigIndentedLists(t *T) {
+	f := testLever(false)
+	f.o.ConfigIndentedLists = true
+
+	repeated, err := f.readConfig(bytes.NewBufferString(
+		"buz: a\nbuz: b\nbuz: c\nfoo: foo\n",
+	))
+	require.Nil(t, err)
+
+	indented, err := f.readConfig(bytes.NewBufferString(
+		"buz:\n  - a\n\t- b\n  # comment\n  - c\nfoo: foo\n",
+	))
+	require.Nil(t, err)
+	assert.Equal(t, repeated, indented)
+
+	// A dedented item isn't part of the list
+	_, err = f.readConfig(bytes.NewBufferString("buz:\n  - a\n- b\n"))
+	assert.NotNil(t, err)
+
+	// Without the option the items aren't understood
+	f.o.ConfigIndentedLists = false
+	_, err = f.readConfig(bytes.NewBufferString("buz:\n  - a\n"))
+	assert.NotNil(t, err)
+}
+
+func TestMapEnv(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--http-log-level", Default: "info"})
+	f.Add(Param{Name: "--db-log-level", Aliases: []string{"-d"}, Default: "info"})
+	f.Add(Param{Name: "--other"})
+	f.MapEnv("LOG_LEVEL", "--http-log-level", "-d")
+
+	require.Nil(t, f.ParseArgs(nil, []string{"LOG_LEVEL=debug"}))
+	httpLevel, _ := f.ParamStr("--http-log-level")
+	dbLevel, _ := f.ParamStr("--db-log-level")
+	_, ok := f.ParamStr("--other")
+	assert.Equal(t, "debug", httpLevel)
+	assert.Equal(t, "debug", dbLevel)
+	assert.False(t, ok)
+
+	// The param's own environment variable and the command line both take
+	// precedence
+	require.Nil(t, f.ParseArgs(
+		[]string{"--http-log-level", "error"},
+		[]string{"LOG_LEVEL=debug", "TEST_APP_DB_LOG_LEVEL=warn"},
+	))
+	httpLevel, _ = f.ParamStr("--http-log-level")
+	dbLevel, _ = f.ParamStr("--db-log-level")
+	assert.Equal(t, "error", httpLevel)
+	assert.Equal(t, "warn", dbLevel)
+
+	assert.Panics(t, func() { f.MapEnv("LOG_LEVEL", "--nope") })
+}
+
+func TestParseErr(t *T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	buf := new(bytes.Buffer)
+	f := New("test-app", &Opts{Output: buf})
+	f.Add(Param{Name: "--foo"})
+
+	os.Args = []string{"test-app", "--help"}
+	assert.Equal(t, ErrHelp, f.ParseErr())
+	assert.Equal(t, f.Help(), buf.String())
+
+	os.Args = []string{"test-app", "--example"}
+	assert.Equal(t, ErrExample, f.ParseErr())
+
+	os.Args = []string{"test-app", "--config", "/does/not/exist"}
+	assert.NotNil(t, f.ParseErr())
+
+	os.Args = []string{"test-app", "--foo", "bar"}
+	require.Nil(t, f.ParseErr())
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+}
+
+func TestParsed(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo", Default: "bar"})
+	assert.False(t, f.Parsed())
+
+	assert.NotNil(t, f.ParseArgs([]string{"--config", "/does/not/exist"}, nil))
+	assert.False(t, f.Parsed())
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.True(t, f.Parsed())
+}
+
+func TestDryRun(t *T) {
+	f := New("test-app", &Opts{DryRunFlag: true})
+	f.Add(Param{Name: "--foo"})
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", "bar"}, nil))
+	assert.False(t, f.DryRun())
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", "bar", "--dry-run"}, nil))
+	assert.True(t, f.DryRun())
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_DRY_RUN=true"}))
+	assert.True(t, f.DryRun())
+
+	f = New("test-app", nil)
+	f.Add(Param{Name: "--dry-run", Flag: true})
+	require.Nil(t, f.ParseArgs([]string{"--dry-run"}, nil))
+	assert.False(t, f.DryRun())
+}
+
+func TestFlexibleDelimiter(t *T) {
+	conf := "foo: a=b\nbar = c:d\nbaz=e\n"
+
+	f := testLever(false)
+	_, err := f.readConfig(bytes.NewBufferString(conf))
+	assert.NotNil(t, err)
+
+	f.o.FlexibleDelimiter = true
+	found, err := f.readConfig(bytes.NewBufferString(conf))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"--foo": {"a=b"},
+		"--bar": {"c:d"},
+		"--baz": {"e"},
+	}, found)
+}
+
+func TestParseArgsPrecedence(t *T) {
+	path := testConfigFile(t, "foo: config\n")
+	defer os.Remove(path)
+
+	for _, test := range []struct {
+		args, env []string
+		expected  string
+	}{
+		{nil, nil, "default"},
+		{[]string{"--config", path}, nil, "config"},
+		{[]string{"--config", path}, []string{"TEST_APP_FOO=env"}, "env"},
+		{nil, []string{"TEST_APP_FOO=env", "TEST_APP_CONFIG=" + path}, "env"},
+		{[]string{"--foo", "cli"}, []string{"TEST_APP_FOO=env"}, "cli"},
+		{[]string{"--config", path, "--foo", "cli"}, nil, "cli"},
+		{[]string{"--foo=cli", "extra"}, []string{"TEST_APP_FOO=env"}, "cli"},
+	} {
+		f := New("test-app", nil)
+		f.Add(Param{Name: "--foo", Default: "default"})
+		require.Nil(t, f.ParseArgs(test.args, test.env))
+		assert.Equal(t, []string{test.expected}, f.found["--foo"], "%v %v", test.args, test.env)
+	}
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	require.Nil(t, f.ParseArgs([]string{"--foo", "a", "--bar", "--", "--foo", "b"}, nil))
+	assert.Equal(t, []string{"a"}, f.found["--foo"])
+	assert.Equal(t, []string{"--bar", "--foo", "b"}, f.remaining)
+}
+
+func TestParamFloat(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--sample-rate", Default: "1"})
+	f.Add(Param{Name: "--thresholds", DefaultMulti: []string{"0.5", "1e3"}})
+
+	require.Nil(t, f.ParseArgs([]string{"--sample-rate", "0.25"}, nil))
+	fl, ok := f.ParamFloat("--sample-rate")
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, fl)
+	fls, ok := f.ParamFloats("--thresholds")
+	assert.True(t, ok)
+	assert.Equal(t, []float64{0.5, 1000}, fls)
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--sample-rate", "lots", "--thresholds", "1", "--thresholds", "x"}, nil,
+	))
+	_, ok = f.ParamFloat("--sample-rate")
+	assert.False(t, ok)
+	_, ok = f.ParamFloats("--thresholds")
+	assert.False(t, ok)
+}
+
+func TestParamHostPort(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--listen"})
+
+	for _, test := range []struct {
+		val  string
+		host string
+		port int
+		ok   bool
+	}{
+		{"0.0.0.0:8080", "0.0.0.0", 8080, true},
+		{"[::1]:443", "::1", 443, true},
+		{":8080", "", 8080, true},
+		{"localhost", "", 0, false},
+		{"localhost:http", "", 0, false},
+		{"localhost:99999", "", 0, false},
+	} {
+		require.Nil(t, f.ParseArgs([]string{"--listen", test.val}, nil))
+		host, port, ok := f.ParamHostPort("--listen")
+		assert.Equal(t, test.host, host, test.val)
+		assert.Equal(t, test.port, port, test.val)
+		assert.Equal(t, test.ok, ok, test.val)
+	}
+}
+
+func TestParamDuration(t *T) {
+	path := testConfigFile(t, "timeout: 500ms\n")
+	defer os.Remove(path)
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--timeout"})
+	f.Add(Param{Name: "--intervals", DefaultMulti: []string{"1s", "1m"}})
+
+	for _, test := range []struct {
+		args, env []string
+		expected  time.Duration
+	}{
+		{[]string{"--timeout", "30s"}, nil, 30 * time.Second},
+		{nil, []string{"TEST_APP_TIMEOUT=1m30s"}, 90 * time.Second},
+		{[]string{"--config", path}, nil, 500 * time.Millisecond},
+	} {
+		require.Nil(t, f.ParseArgs(test.args, test.env))
+		d, ok := f.ParamDuration("--timeout")
+		assert.True(t, ok)
+		assert.Equal(t, test.expected, d)
+	}
+
+	ds, ok := f.ParamDurations("--intervals")
+	assert.True(t, ok)
+	assert.Equal(t, []time.Duration{time.Second, time.Minute}, ds)
+
+	require.Nil(t, f.ParseArgs([]string{"--timeout", "30", "--intervals", "soon"}, nil))
+	_, ok = f.ParamDuration("--timeout")
+	assert.False(t, ok)
+	_, ok = f.ParamDurations("--intervals")
+	assert.False(t, ok)
+}
+
+func TestParamRestUnknownVerbatim(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--bar", "--foo", "a", "baz", "--", "--foo", "b", "--"}, nil,
+	))
+	assert.Equal(t, []string{"--bar", "baz"}, f.ParamRestUnknown())
+	assert.Equal(t, []string{"--foo", "b", "--"}, f.ParamRestVerbatim())
+	assert.Equal(t, []string{"--bar", "baz", "--foo", "b", "--"}, f.ParamRest())
+
+	require.Nil(t, f.ParseArgs([]string{"--bar"}, nil))
+	assert.Equal(t, []string{"--bar"}, f.ParamRestUnknown())
+	assert.Empty(t, f.ParamRestVerbatim())
+
+	require.Nil(t, f.ParseWithoutCLI([]string{"--bar", "baz"}))
+	assert.Empty(t, f.ParamRestUnknown())
+	assert.Equal(t, []string{"--bar", "baz"}, f.ParamRestVerbatim())
+}
+
+func TestParseFlagsOnly(t *T) {
+	f := New("test-app", &Opts{StrictUnknown: true})
+	f.Add(Param{Name: "--fast", Flag: true})
+	f.Add(Param{Name: "--slow", Flag: true, Default: "true"})
+	f.Add(Param{Name: "--foo", Aliases: []string{"-f"}, Default: "def"})
+
+	args := []string{"--foo", "--fast", "--fast", "-f=a", "extra", "--", "--slow"}
+	require.Nil(t, f.ParseFlagsOnly(args))
+	assert.True(t, f.ParamFlag("--fast"))
+	assert.True(t, f.ParamFlag("--slow"))
+	_, ok := f.ParamStr("--foo")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"--foo", "--fast", "-f=a", "extra"}, f.ParamRestUnknown())
+	assert.Equal(t, []string{"--slow"}, f.ParamRestVerbatim())
+	assert.False(t, f.Parsed())
+
+	// Params can be added depending on the flags, and then fully parsed
+	f.Add(Param{Name: "--turbo"})
+	require.Nil(t, f.ParseArgs(
+		[]string{"--foo", "b", "--fast", "--turbo", "yes", "-f=a"}, nil,
+	))
+	foo, _ := f.ParamStrs("--foo")
+	turbo, _ := f.ParamStr("--turbo")
+	assert.Equal(t, []string{"b", "a"}, foo)
+	assert.Equal(t, "yes", turbo)
+	assert.True(t, f.ParamFlag("--fast"))
+
+	assert.NotNil(t, f.ParseFlagsOnly([]string{"--wat"}))
+
+	// Flags can be read from a given environment
+	require.Nil(t, f.ParseFlagsOnlyArgs(nil, []string{
+		"TEST_APP_SLOW=false", "TEST_APP_FOO=c",
+	}))
+	assert.False(t, f.ParamFlag("--slow"))
+	_, ok = f.ParamStr("--foo")
+	assert.False(t, ok)
+}
+
+func TestTerminatesParsing(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--exec", Flag: true, TerminatesParsing: true})
+	f.Add(Param{Name: "--run", TerminatesParsing: true})
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--bar", "--foo", "a", "--exec", "ls", "--foo", "b", "--", "-l"}, nil,
+	))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "a", foo)
+	assert.True(t, f.ParamFlag("--exec"))
+	assert.Equal(t, []string{"--bar"}, f.ParamRestUnknown())
+	assert.Equal(t, []string{"ls", "--foo", "b", "--", "-l"}, f.ParamRestVerbatim())
+
+	require.Nil(t, f.ParseArgs([]string{"--run=sh", "--exec", "-c"}, nil))
+	run, _ := f.ParamStr("--run")
+	assert.Equal(t, "sh", run)
+	assert.False(t, f.ParamFlag("--exec"))
+	assert.Equal(t, []string{"--exec", "-c"}, f.ParamRestVerbatim())
+
+	require.Nil(t, f.ParseArgs([]string{"--run", "sh", "--foo", "a"}, nil))
+	run, _ = f.ParamStr("--run")
+	assert.Equal(t, "sh", run)
+	_, ok := f.ParamStr("--foo")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"--foo", "a"}, f.ParamRestVerbatim())
+}
+
+func TestAddComputed(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--region", Default: "us-east-1"})
+	f.Add(Param{Name: "--bucket"})
+	f.AddComputed("--cache-key", func(f *Lever) (string, error) {
+		region, _ := f.ParamStr("--region")
+		bucket, ok := f.ParamStr("--bucket")
+		if !ok {
+			return "", errors.New("needs --bucket")
+		}
+		return region + "/" + bucket, nil
+	})
+
+	require.Nil(t, f.ParseArgs([]string{"--bucket", "stuff"}, nil))
+	key, ok := f.ParamStr("--cache-key")
+	assert.True(t, ok)
+	assert.Equal(t, "us-east-1/stuff", key)
+
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_REGION=eu-west-1", "TEST_APP_BUCKET=b"}))
+	key, _ = f.ParamStr("--cache-key")
+	assert.Equal(t, "eu-west-1/b", key)
+	assert.NotContains(t, f.Example(), "cache-key")
+	assert.NotContains(t, f.Help(), "cache-key")
+
+	assert.Equal(t, &ParamError{
+		Param:   "--cache-key",
+		Source:  SourceComputed,
+		Message: "needs --bucket",
+	}, f.ParseArgs(nil, nil))
+
+	path := testConfigFile(t, "cache-key: nope\n")
+	defer os.Remove(path)
+	err := f.ParseArgs(
+		[]string{"--bucket", "b", "--cache-key=nope", "--config", path},
+		[]string{"TEST_APP_CACHE_KEY=nope"},
+	)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--cache-key", Source: SourceCLI, Message: "computed param can't be set"},
+		&ParamError{Param: "--cache-key", Source: SourceEnv, Message: "computed param can't be set"},
+		&ParamError{Param: "--cache-key", Source: SourceConfig, Message: "computed param can't be set"},
+	}, err)
+}
+
+func TestStrictUnknown(t *T) {
+	f := New("test-app", &Opts{StrictUnknown: true})
+	f.Add(Param{Name: "--verbose", Flag: true})
+
+	err := f.ParseArgs([]string{"--verbsoe", "-x=1", "pos", "-", "--", "--other"}, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--verbsoe", Source: SourceCLI, Message: "unknown flag"},
+		&ParamError{Param: "-x", Source: SourceCLI, Message: "unknown flag"},
+	}, err)
+
+	require.Nil(t, f.ParseArgs([]string{"--verbose", "pos", "-", "--", "--other"}, nil))
+	assert.Equal(t, []string{"pos", "-", "--other"}, f.ParamRest())
+}
+
+func TestDump(t *T) {
+	f := testLever(false)
+	buf := new(bytes.Buffer)
+	assert.NotNil(t, f.Dump(buf))
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--foo", ` spaced \ out `, "--flag1", "--buz", "x", "--buz", "y"}, nil,
+	))
+	require.Nil(t, f.Dump(buf))
+	assert.Equal(t, `# wut
+baz: wat
+
+# wut
+buz: x
+buz: y
+
+flag1: true
+
+flag2: false
+
+foo: \ spaced \\ out\ 
+
+`, buf.String())
+
+	// Reading the dump back in gives the same values
+	path := testConfigFile(t, buf.String())
+	defer os.Remove(path)
+	f2 := testLever(false)
+	require.Nil(t, f2.ParseArgs([]string{"--config", path}, nil))
+	for _, name := range []string{"--foo", "--baz", "--buz", "--flag1", "--flag2"} {
+		vs, _ := f.ParamStrs(name)
+		vs2, _ := f2.ParamStrs(name)
+		if name == "--flag1" || name == "--flag2" {
+			assert.Equal(t, f.ParamFlag(name), f2.ParamFlag(name), name)
+			continue
+		}
+		assert.Equal(t, vs, vs2, name)
+	}
+}
+
+func TestRedact(t *T) {
+	lastFour := func(v string) string {
+		if len(v) <= 4 {
+			return strings.Repeat("*", len(v))
+		}
+		return strings.Repeat("*", len(v)-4) + v[len(v)-4:]
+	}
+
+	f := New("test-app", &Opts{ExampleHeader: "-"})
+	f.Add(Param{Name: "--token", Default: "abcdefgh", Redact: lastFour})
+	f.Add(Param{Name: "--password", Sensitive: true})
+	f.Add(Param{Name: "--keys", DefaultMulti: []string{"k1", "k2"}, Sensitive: true})
+
+	assert.Contains(t, f.Help(), "\t--token\n\t\tDefault: ****efgh\n")
+	assert.Contains(t, f.Help(), "\t--keys\n\t\tDefault: [<redacted> <redacted>]\n")
+	assert.Equal(t, `keys: <redacted>
+keys: <redacted>
+
+password: 
+
+token: ****efgh
+
+`, f.Example())
+
+	env := []string{"TEST_APP_PASSWORD=hunter2"}
+	assert.Contains(t, f.DumpEnv(env), "TEST_APP_PASSWORD=<redacted>\n")
+
+	require.Nil(t, f.ParseArgs([]string{"--token", "secret-1234", "--password", "hunter2"}, env))
+	buf := new(bytes.Buffer)
+	require.Nil(t, f.Dump(buf))
+	assert.Contains(t, buf.String(), "token: *******1234\n")
+	assert.Contains(t, buf.String(), "password: <redacted>\n")
+
+	// EnvScript reproduces the configuration, so it isn't redacted
+	assert.Contains(t, f.EnvScript(), "export TEST_APP_PASSWORD='hunter2'\n")
+
+	// The real values are still available
+	token, _ := f.ParamStr("--token")
+	password, _ := f.ParamStr("--password")
+	assert.Equal(t, "secret-1234", token)
+	assert.Equal(t, "hunter2", password)
+}
+
+func TestRedactErrors(t *T) {
+	one := 1
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--level", Choices: []string{"debug", "info"}, Sensitive: true})
+	f.Add(Param{Name: "--pin", Max: &one, Redact: func(string) string { return "****" }})
+
+	err := f.ParseArgs([]string{"--level", "hunter2", "--pin", "1234"}, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--level", Source: SourceCLI, Message: `invalid value "<redacted>", must be one of: debug, info`},
+		&ParamError{Param: "--pin", Source: SourceCLI, Message: "**** is greater than the maximum of 1"},
+	}, err)
+
+	err = f.ParseArgs([]string{"--pin", "secret"}, nil)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--pin", Source: SourceCLI, Message: `invalid integer "****"`},
+	}, err)
+}
+
+func TestEnvPrefixSeparator(t *T) {
+	f := New("test-app", &Opts{EnvPrefixSeparator: ".", StrictEnv: true})
+	f.Add(Param{Name: "--foo-bar"})
+
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP.FOO_BAR=a", "TEST_APP_FOO_BAR=b"}))
+	v, _ := f.ParamStr("--foo-bar")
+	assert.Equal(t, "a", v)
+
+	err := f.ParseArgs(nil, []string{"TEST_APP.BAZ=a"})
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "TEST_APP.BAZ",
+		Source:  SourceEnv,
+		Message: "unknown environment variable",
+	}}, err)
+}
+
+func TestJSONConfig(t *T) {
+	conf := `{"foo": "a: b", "buz": ["x", 2, true], "bar": 1.5, "baz": null, "raw": {"k": [1]}}` + "\n"
+	newLever := func(o *Opts) *Lever {
+		f := New("test-app", o)
+		f.Add(Param{Name: "--foo"})
+		f.Add(Param{Name: "--bar"})
+		f.Add(Param{Name: "--baz", Default: "wat"})
+		f.Add(Param{Name: "--buz", DefaultMulti: []string{}})
+		f.Add(Param{Name: "--raw"})
+		return f
+	}
+	check := func(f *Lever) {
+		foo, _ := f.ParamStr("--foo")
+		bar, _ := f.ParamFloat("--bar")
+		baz, _ := f.ParamStr("--baz")
+		buz, _ := f.ParamStrs("--buz")
+		raw, _ := f.ParamRawJSON("--raw")
+		assert.Equal(t, "a: b", foo)
+		assert.Equal(t, 1.5, bar)
+		assert.Equal(t, "wat", baz)
+		assert.Equal(t, []string{"x", "2", "true"}, buz)
+		assert.Equal(t, `{"k": [1]}`, string(raw))
+	}
+
+	fd, err := os.CreateTemp("", "lever-test*.json")
+	require.Nil(t, err)
+	defer os.Remove(fd.Name())
+	_, err = fd.WriteString(conf)
+	require.Nil(t, err)
+	require.Nil(t, fd.Close())
+
+	f := newLever(nil)
+	require.Nil(t, f.ParseArgs([]string{"--config", fd.Name()}, nil))
+	check(f)
+
+	path := testConfigFile(t, conf)
+	defer os.Remove(path)
+	// Without the extension the file is read as native, and nothing matches
+	f = newLever(nil)
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	_, ok := f.ParamStr("--foo")
+	assert.False(t, ok)
+
+	f = newLever(&Opts{ConfigFormat: ConfigFormatJSON})
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	check(f)
+
+	f = newLever(&Opts{ConfigFormat: ConfigFormatNative})
+	require.Nil(t, f.ParseArgs([]string{"--config", fd.Name()}, nil))
+	_, ok = f.ParamStr("--foo")
+	assert.False(t, ok)
+
+	bad := testConfigFile(t, `{"foo": `)
+	defer os.Remove(bad)
+	f = newLever(&Opts{ConfigFormat: ConfigFormatJSON})
+	assert.NotNil(t, f.ParseArgs([]string{"--config", bad}, nil))
+}
+
+func TestConfigFormatFallback(t *T) {
+	jsonPath := testConfigFile(t, `{"foo": "a", "bar": ["b", "c"]}`+"\n")
+	defer os.Remove(jsonPath)
+	nativePath := testConfigFile(t, "foo: x\n")
+	defer os.Remove(nativePath)
+	unknownPath := testConfigFile(t, "wat: x\n")
+	defer os.Remove(unknownPath)
+
+	f := New("test-app", &Opts{
+		ConfigFormatFallback: []ConfigFormat{ConfigFormatNative, ConfigFormatJSON},
+	})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+
+	require.Nil(t, f.ParseArgs([]string{"--config", jsonPath}, nil))
+	foo, _ := f.ParamStr("--foo")
+	bar, _ := f.ParamStrs("--bar")
+	assert.Equal(t, "a", foo)
+	assert.Equal(t, []string{"b", "c"}, bar)
+	assert.Equal(t, ConfigFormatJSON, f.ConfigFormatUsed())
+
+	require.Nil(t, f.ParseArgs([]string{"--config", nativePath}, nil))
+	foo, _ = f.ParamStr("--foo")
+	assert.Equal(t, "x", foo)
+	assert.Equal(t, ConfigFormatNative, f.ConfigFormatUsed())
+
+	// Nothing matches, so the first format which could be read is used
+	require.Nil(t, f.ParseArgs([]string{"--config", unknownPath}, nil))
+	assert.Equal(t, ConfigFormatNative, f.ConfigFormatUsed())
+	f.o.StrictConfig = true
+	err := f.ParseArgs([]string{"--config", unknownPath}, nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "wat")
+	f.o.StrictConfig = false
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.Equal(t, ConfigFormatAuto, f.ConfigFormatUsed())
+
+	f.o.MaxConfigBytes = 16
+	err = f.ParseArgs([]string{"--config", jsonPath}, nil)
+	assert.Equal(t,
+		fmt.Sprintf("error reading %s: exceeds maximum size of 16 bytes", jsonPath),
+		err.Error())
+}
+
+func TestParseRequireConfig(t *T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+
+	os.Args = []string{"test-app", "--config", "/does/not/exist"}
+	assert.NotNil(t, f.ParseRequireConfig(true))
+	require.Nil(t, f.ParseRequireConfig(false))
+	assert.False(t, f.o.AllowMissingConfigFile)
+
+	os.Args = []string{"test-app"}
+	assert.Equal(t, errors.New("no config file given"), f.ParseRequireConfig(true))
+	require.Nil(t, f.ParseRequireConfig(false))
+
+	path := testConfigFile(t, "foo: bar\n")
+	defer os.Remove(path)
+	os.Args = []string{"test-app", "--config", path}
+	require.Nil(t, f.ParseRequireConfig(true))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+}
+
+// testDecoder reads a config file where each line is "name=value", with the
+// full param name
+type testDecoder struct{}
+
+func (testDecoder) Decode(r io.Reader, expected map[string]*Param) (map[string][]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]string{}
+	for _, line := range strings.Fields(string(b)) {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad line %q", line)
+		}
+		out[parts[0]] = append(out[parts[0]], parts[1])
+	}
+	return out, nil
+}
+
+func TestConfigDecoder(t *T) {
+	f := testLever(false)
+	f.o.ConfigDecoder = testDecoder{}
+	f.o.StrictConfig = true
+
+	path := testConfigFile(t, "--foo=a\n--buz=x\n--buz=y\n")
+	defer os.Remove(path)
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	foo, _ := f.ParamStr("--foo")
+	buz, _ := f.ParamStrs("--buz")
+	assert.Equal(t, "a", foo)
+	assert.Equal(t, []string{"x", "y"}, buz)
+
+	unknown := testConfigFile(t, "--nope=a\n")
+	defer os.Remove(unknown)
+	assert.NotNil(t, f.ParseArgs([]string{"--config", unknown}, nil))
+
+	bad := testConfigFile(t, "--foo\n")
+	defer os.Remove(bad)
+	assert.NotNil(t, f.ParseArgs([]string{"--config", bad}, nil))
+
+	// MaxConfigBytes applies to the decoder too
+	f.o.MaxConfigBytes = 15
+	err := f.ParseArgs([]string{"--config", path}, nil)
+	assert.Equal(t,
+		fmt.Sprintf("error reading %s: exceeds maximum size of 15 bytes", path),
+		err.Error())
+}
+
+func TestAcceptJSONArray(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--buz", AcceptJSONArray: true})
+	f.Add(Param{Name: "--raw"})
+
+	require.Nil(t, f.ParseArgs([]string{
+		"--buz", `["a", "b"]`, "--buz", "c", "--buz=[1,true]", "--buz", "[not json",
+		"--raw", `["a"]`,
+	}, nil))
+	buz, _ := f.ParamStrs("--buz")
+	raw, _ := f.ParamStrs("--raw")
+	assert.Equal(t, []string{"a", "b", "c", "1", "true", "[not json"}, buz)
+	assert.Equal(t, []string{`["a"]`}, raw)
+}
+
+func TestConstraints(t *T) {
+	f := New("test-app", &Opts{RequiredIgnoresDefault: true})
+	f.Add(Param{Name: "--db-url", Aliases: []string{"-d"}, Required: true})
+	f.Add(Param{Name: "--peers", MinValues: 1, MaxValues: 3})
+	f.Add(Param{Name: "--debug", Flag: true})
+	f.Add(Param{Name: "--tls", Flag: true, Required: true})
+
+	expected := ParamConstraints{Required: true, RequiredIgnoresDefault: true}
+	assert.Equal(t, expected, f.Constraints("--db-url"))
+	assert.Equal(t, expected, f.Constraints("-d"))
+	assert.Equal(t, ParamConstraints{MinValues: 1, MaxValues: 3}, f.Constraints("--peers"))
+	assert.Equal(t, ParamConstraints{Bool: true}, f.Constraints("--debug"))
+	assert.Equal(t, ParamConstraints{
+		Required:               true,
+		RequiredIgnoresDefault: true,
+		Bool:                   true,
+	}, f.Constraints("--tls"))
+	assert.Equal(t, ParamConstraints{}, f.Constraints("--nope"))
+}
+
+func TestParamBool(t *T) {
+	path := testConfigFile(t, "enable-tls: false\n")
+	defer os.Remove(path)
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--enable-tls", Default: "true"})
+	f.Add(Param{Name: "--other"})
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	b, ok := f.ParamBool("--enable-tls")
+	assert.True(t, ok)
+	assert.True(t, b)
+	_, ok = f.ParamBool("--other")
+	assert.False(t, ok)
+
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	b, ok = f.ParamBool("--enable-tls")
+	assert.True(t, ok)
+	assert.False(t, b)
+
+	for v, expected := range map[string]bool{"1": true, "t": true, "0": false, "F": false} {
+		require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_ENABLE_TLS=" + v}))
+		b, ok = f.ParamBool("--enable-tls")
+		assert.True(t, ok, v)
+		assert.Equal(t, expected, b, v)
+	}
+
+	require.Nil(t, f.ParseArgs([]string{"--enable-tls", "maybe"}, nil))
+	_, ok = f.ParamBool("--enable-tls")
+	assert.False(t, ok)
+}
+
+func TestReadConfigExactMatch(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--other-foo"})
+	f.Add(Param{Name: "--bar"})
+
+	found, unknown := f.matchConfigKeys(map[string][]string{
+		"foo": {"a"},
+		"ar":  {"b"},
+		"o":   {"c"},
+	})
+	assert.Equal(t, map[string][]string{"--foo": {"a"}}, found)
+	assert.Equal(t, map[string][]string{"ar": {"b"}, "o": {"c"}}, unknown)
+}
+
+func TestEnvPresenceIsTrue(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--debug", Flag: true, EnvPresenceIsTrue: true})
+	f.Add(Param{Name: "--verbose", Flag: true})
+
+	for _, env := range []string{"", "1", "false"} {
+		require.Nil(t, f.ParseArgs(nil, []string{
+			"TEST_APP_DEBUG=" + env, "TEST_APP_VERBOSE=" + env,
+		}))
+		assert.True(t, f.ParamFlag("--debug"), env)
+		assert.Equal(t, env == "1", f.ParamFlag("--verbose"), env)
+	}
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.False(t, f.ParamFlag("--debug"))
+
+	// A false presence flag is left out of EnvScript, since sourcing the
+	// script would otherwise make it true
+	conf := testConfigFile(t, "debug: false\nverbose: false\n")
+	defer os.Remove(conf)
+	require.Nil(t, f.ParseArgs([]string{"--config", conf}, nil))
+	assert.Equal(t, "export TEST_APP_CONFIG="+shellQuote(conf)+"\n"+
+		"export TEST_APP_VERBOSE='false'\n", f.EnvScript())
+
+	require.Nil(t, f.ParseArgs([]string{"--debug", "--config", conf}, nil))
+	assert.Contains(t, f.EnvScript(), "export TEST_APP_DEBUG='true'\n")
+}
+
+func TestConfigProfiles(t *T) {
+	dir, err := os.MkdirTemp("", "lever-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	base := dir + "/config.conf"
+	require.Nil(t, os.WriteFile(base, []byte("foo: base\nbar: base\nprofile: dev\n"), 0600))
+	require.Nil(t, os.WriteFile(dir+"/config.dev.conf", []byte("bar: dev\n"), 0600))
+	require.Nil(t, os.WriteFile(dir+"/config.prod.conf", []byte("bar: prod\nfoo: prod\n"), 0600))
+
+	f := New("test-app", &Opts{ConfigProfiles: true})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+	check := func(args, env []string, foo, bar string) {
+		require.Nil(t, f.ParseArgs(append([]string{"--config", base}, args...), env))
+		v, _ := f.ParamStr("--foo")
+		assert.Equal(t, foo, v, "%v %v", args, env)
+		v, _ = f.ParamStr("--bar")
+		assert.Equal(t, bar, v, "%v %v", args, env)
+	}
+
+	// The profile given in the base config file
+	check(nil, nil, "base", "dev")
+	check(nil, []string{"TEST_APP_PROFILE=prod"}, "prod", "prod")
+	check([]string{"--profile", "prod"}, []string{"TEST_APP_PROFILE=dev"}, "prod", "prod")
+	check([]string{"--profile", "prod"}, []string{"TEST_APP_FOO=env"}, "env", "prod")
+	check([]string{"--profile", "prod", "--bar", "cli"}, nil, "prod", "cli")
+	check([]string{"--profile", ""}, nil, "base", "base")
+
+	assert.NotNil(t, f.ParseArgs([]string{"--config", base, "--profile", "nope"}, nil))
+}
+
+func TestDisallowInEnv(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--secret", DisallowInEnv: true, Default: "none"})
+	f.Add(Param{Name: "--foo"})
+
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_SECRET=leaked", "TEST_APP_FOO=foo"}))
+	secret, _ := f.ParamStr("--secret")
+	assert.Equal(t, "none", secret)
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "foo", foo)
+
+	require.Nil(t, f.ParseArgs([]string{"--secret", "shh"}, []string{"TEST_APP_SECRET=leaked"}))
+	secret, _ = f.ParamStr("--secret")
+	assert.Equal(t, "shh", secret)
+	assert.NotContains(t, f.DumpEnv(nil), "TEST_APP_SECRET")
+	assert.NotContains(t, f.EnvScript(), "TEST_APP_SECRET")
+
+	// The variable is ignored, not unknown
+	f.o.StrictEnv = true
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_SECRET=leaked"}))
+	secret, _ = f.ParamStr("--secret")
+	assert.Equal(t, "none", secret)
+	assert.NotNil(t, f.ParseArgs(nil, []string{"TEST_APP_WAT=x"}))
+}
+
+func TestEnvSeparator(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--path", EnvSeparator: ":"})
+	f.Add(Param{Name: "--tags", EnvSeparator: ","})
+	f.Add(Param{Name: "--other"})
+
+	require.Nil(t, f.ParseArgs(nil, []string{
+		"TEST_APP_PATH=a:b,c", "TEST_APP_TAGS=x,y:z", "TEST_APP_OTHER=1,2",
+	}))
+	path, _ := f.ParamStrs("--path")
+	tags, _ := f.ParamStrs("--tags")
+	other, _ := f.ParamStrs("--other")
+	assert.Equal(t, []string{"a", "b,c"}, path)
+	assert.Equal(t, []string{"x", "y:z"}, tags)
+	assert.Equal(t, []string{"1,2"}, other)
+	assert.Contains(t, f.EnvScript(), "export TEST_APP_PATH='a:b,c'\n")
+}
+
+func TestHelpOnError(t *T) {
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	f := New("test-app", &Opts{
+		Output:        out,
+		ErrOutput:     errOut,
+		HelpOnError:   true,
+		StrictUnknown: true,
+	})
+	f.Add(Param{Name: "--foo"})
+
+	assert.Equal(t, ErrHelp, f.ParseArgs([]string{"--help"}, nil))
+	assert.Equal(t, f.Help(), out.String())
+	assert.Empty(t, errOut.String())
+
+	out.Reset()
+	err := f.ParseArgs([]string{"--fooo"}, nil)
+	require.NotNil(t, err)
+	f.writeError(f.errOutput(), err)
+	assert.Empty(t, out.String())
+	assert.Equal(t, "cli: --fooo: unknown flag\n"+f.Help(), errOut.String())
+}
+
+func TestAppNameFromArgv0(t *T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	newLever := func() *Lever {
+		f := New("test-app", &Opts{AppNameFromArgv0: true})
+		f.Add(Param{Name: "--foo"})
+		return f
+	}
+
+	os.Args = []string{"/usr/local/bin/other-tool", "--foo", "a"}
+	f := newLever()
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_FOO=a", "OTHER_TOOL_FOO=b"}))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "b", foo)
+	assert.True(t, strings.HasPrefix(f.Example(), "# other-tool configuration\n"))
+
+	os.Args = []string{`other-tool.exe`}
+	f = newLever()
+	require.Nil(t, f.ParseArgs(nil, []string{"OTHER_TOOL_FOO=b"}))
+	foo, _ = f.ParamStr("--foo")
+	assert.Equal(t, "b", foo)
+
+	for _, argv := range [][]string{nil, {""}} {
+		os.Args = argv
+		f = newLever()
+		require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_FOO=a"}))
+		foo, _ = f.ParamStr("--foo")
+		assert.Equal(t, "a", foo)
+	}
+}
+
+func TestEnvPrefix(t *T) {
+	f := New("my-service", &Opts{EnvPrefix: "mysvc", StrictEnv: true})
+	f.Add(Param{Name: "--foo-bar"})
+
+	require.Nil(t, f.ParseArgs(nil, []string{"MYSVC_FOO_BAR=a", "MY_SERVICE_FOO_BAR=b"}))
+	v, _ := f.ParamStr("--foo-bar")
+	assert.Equal(t, "a", v)
+	assert.NotNil(t, f.ParseArgs(nil, []string{"MYSVC_BAZ=a"}))
+	assert.Contains(t, f.DumpEnv(nil), "MYSVC_FOO_BAR (unset)\n")
+}
+
+func TestConfigReader(t *T) {
+	f := New("test-app", &Opts{
+		ConfigReader: bytes.NewBufferString("foo: reader\nbar: reader\n"),
+	})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+	check := func(args, env []string, foo, bar string) {
+		require.Nil(t, f.ParseArgs(args, env))
+		v, _ := f.ParamStr("--foo")
+		assert.Equal(t, foo, v, "%v %v", args, env)
+		v, _ = f.ParamStr("--bar")
+		assert.Equal(t, bar, v, "%v %v", args, env)
+	}
+
+	path := testConfigFile(t, "bar: file\n")
+	defer os.Remove(path)
+
+	check(nil, nil, "reader", "reader")
+	check([]string{"--config", path}, nil, "reader", "file")
+	check([]string{"--foo", "cli"}, []string{"TEST_APP_BAR=env"}, "cli", "env")
+	// The reader is only read once, but its values are kept
+	check(nil, nil, "reader", "reader")
+}
+
+func TestShortFlagCluster(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--all", Aliases: []string{"-a"}, Flag: true})
+	f.Add(Param{Name: "--brief", Aliases: []string{"-b"}, Flag: true})
+	f.Add(Param{Name: "--color", Aliases: []string{"-c"}, Flag: true, Default: "true"})
+	f.Add(Param{Name: "--output", Aliases: []string{"-o"}})
+
+	require.Nil(t, f.ParseArgs([]string{"-abc", "-bo", "out.txt", "pos"}, nil))
+	assert.True(t, f.ParamFlag("--all"))
+	assert.True(t, f.ParamFlag("--brief"))
+	assert.False(t, f.ParamFlag("--color"))
+	out, _ := f.ParamStr("--output")
+	assert.Equal(t, "out.txt", out)
+	assert.Equal(t, []string{"pos"}, f.ParamRest())
+
+	// An unknown character, or a param taking a value which isn't last, means
+	// the whole token isn't expanded
+	require.Nil(t, f.ParseArgs([]string{"-abx", "-oa", "--", "-ab"}, nil))
+	assert.False(t, f.ParamFlag("--all"))
+	_, ok := f.ParamStr("--output")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"-abx", "-oa", "-ab"}, f.ParamRest())
+}
+
+func TestSourcePriority(t *T) {
+	path := testConfigFile(t, "policy: strict\nfoo: config\n")
+	defer os.Remove(path)
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--policy", SourcePriority: []Source{SourceConfig}})
+	f.Add(Param{Name: "--foo"})
+
+	require.Nil(t, f.ParseArgs(
+		[]string{"--config", path, "--policy", "lax", "--foo", "cli"}, nil,
+	))
+	policy, _ := f.ParamStr("--policy")
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "strict", policy)
+	assert.Equal(t, "cli", foo)
+
+	// Without a config file the other sources keep their normal order
+	require.Nil(t, f.ParseArgs(
+		[]string{"--policy", "lax"}, []string{"TEST_APP_POLICY=env"},
+	))
+	policy, _ = f.ParamStr("--policy")
+	assert.Equal(t, "lax", policy)
+}
+
+func TestChoices(t *T) {
+	levels := []string{"debug", "info", "warn", "error"}
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--log-level", Choices: levels, Default: "info"})
+	f.Add(Param{Name: "--modules", Choices: []string{"a", "b"}, DefaultMulti: []string{}})
+
+	require.Nil(t, f.ParseArgs([]string{"--modules", "a", "--modules", "b"}, nil))
+	require.Nil(t, f.ParseArgs(nil, []string{"TEST_APP_LOG_LEVEL=warn"}))
+
+	err := f.ParseArgs(
+		[]string{"--log-level", "loud", "--modules", "a", "--modules", "c"}, nil,
+	)
+	assert.Equal(t, MultiError{
+		&ParamError{
+			Param:   "--log-level",
+			Source:  SourceCLI,
+			Message: `invalid value "loud", must be one of: debug, info, warn, error`,
+		},
+		&ParamError{
+			Param:   "--modules",
+			Source:  SourceCLI,
+			Message: `invalid value "c", must be one of: a, b`,
+		},
+	}, err)
+
+	assert.Contains(t, f.Help(), "\t--log-level\n\t\tChoices: debug, info, warn, error\n\t\tDefault: info\n")
+	assert.Equal(t, levels, f.Constraints("--log-level").Choices)
+}
+
+func TestSeed(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo", Default: "default"})
+	f.Add(Param{Name: "--bar", Aliases: []string{"-b"}, Default: "default"})
+	f.Add(Param{Name: "--baz", Default: "default"})
+	f.Seed(map[string][]string{"--foo": {"seed"}, "-b": {"seed"}})
+
+	require.Nil(t, f.ParseArgs([]string{"--foo", "cli"}, nil))
+	for name, expected := range map[string]string{
+		"--foo": "cli",
+		"--bar": "seed",
+		"--baz": "default",
+	} {
+		v, _ := f.ParamStr(name)
+		assert.Equal(t, expected, v, name)
+	}
+	assert.Equal(t, SourceSeed, f.foundSource["--bar"])
+
+	path := testConfigFile(t, "bar: config\n")
+	defer os.Remove(path)
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	bar, _ := f.ParamStr("--bar")
+	assert.Equal(t, "config", bar)
+}
+
+func TestMinMax(t *T) {
+	one, ten, zero := 1, 10, 0
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--workers", Min: &one, Max: &ten, Default: "4"})
+	f.Add(Param{Name: "--retries", Min: &zero})
+	f.Add(Param{Name: "--offset", Max: &zero})
+
+	require.Nil(t, f.ParseArgs([]string{"--retries", "0", "--offset", "-5"}, nil))
+	require.Nil(t, f.ParseArgs([]string{"--workers", "10"}, nil))
+
+	err := f.ParseArgs(
+		[]string{"--workers", "11", "--retries", "-1", "--offset", "lots"}, nil,
+	)
+	assert.Equal(t, MultiError{
+		&ParamError{Param: "--offset", Source: SourceCLI, Message: `invalid integer "lots"`},
+		&ParamError{Param: "--retries", Source: SourceCLI, Message: "-1 is less than the minimum of 0"},
+		&ParamError{Param: "--workers", Source: SourceCLI, Message: "11 is greater than the maximum of 10"},
+	}, err)
+
+	help := f.Help()
+	assert.Contains(t, help, "\t--workers\n\t\tRange: 1 to 10\n\t\tDefault: 4\n")
+	assert.Contains(t, help, "\t--retries\n\t\tRange: at least 0\n")
+	assert.Contains(t, help, "\t--offset\n\t\tRange: at most 0\n")
+}
+
+func TestRequireSecureConfigPerms(t *T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions aren't checked on windows")
+	}
+
+	path := testConfigFile(t, "foo: bar\n")
+	defer os.Remove(path)
+	require.Nil(t, os.Chmod(path, 0644))
+
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--foo"})
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+
+	f.o.RequireSecureConfigPerms = true
+	assert.Equal(t,
+		errors.New(path+" is readable by group or others (mode 0644)"),
+		f.ParseArgs([]string{"--config", path}, nil),
+	)
+
+	require.Nil(t, os.Chmod(path, 0600))
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	foo, _ := f.ParamStr("--foo")
+	assert.Equal(t, "bar", foo)
+}
+
+func TestDefaultedParams(t *T) {
+	f := testLever(false)
+	assert.Empty(t, f.DefaultedParams())
+
+	require.Nil(t, f.ParseArgs([]string{"--buz", "x"}, []string{"TEST_APP_FOO=foo"}))
+	assert.Equal(t, []string{"--baz", "--byz"}, f.DefaultedParams())
+
+	require.Nil(t, f.ParseArgs([]string{"--baz", "wat"}, nil))
+	assert.Equal(t, []string{"--buz", "--byz"}, f.DefaultedParams())
+}
+
+func TestValidate(t *T) {
+	validateAddr := func(v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return fmt.Errorf("not a host:port: %s", err)
+		}
+		return nil
+	}
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--listen-addr", Validate: validateAddr, Default: ":8080"})
+	f.Add(Param{Name: "--peers", Validate: validateAddr, DefaultMulti: []string{}})
+
+	require.Nil(t, f.ParseArgs([]string{"--peers", "a:1", "--peers", "b:2"}, nil))
+
+	err := f.ParseArgs(nil, []string{"TEST_APP_LISTEN_ADDR=8080"})
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "--listen-addr",
+		Source:  SourceEnv,
+		Message: "not a host:port: address 8080: missing port in address",
+	}}, err)
+
+	err = f.ParseArgs([]string{"--peers", "a:1", "--peers", "b"}, nil)
+	assert.Equal(t, MultiError{&ParamError{
+		Param:   "--peers",
+		Source:  SourceCLI,
+		Message: "not a host:port: address b: missing port in address",
+	}}, err)
+}
+
+func TestParamBytes(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--size"})
+
+	for v, expect := range map[string]int64{
+		"100":    100,
+		"100B":   100,
+		"512KB":  512000,
+		"512k":   512000,
+		"10 MB":  10000000,
+		"2GB":    2000000000,
+		"1T":     1000000000000,
+		"1KiB":   1024,
+		"1.5Ki":  1536,
+		"4mib":   4 << 20,
+		"2GiB":   2 << 30,
+		"1TiB":   1 << 40,
+		"0.5MiB": 1 << 19,
+	} {
+		require.Nil(t, f.ParseArgs([]string{"--size", v}, nil))
+		b, ok := f.ParamBytes("--size")
+		assert.True(t, ok, v)
+		assert.Equal(t, expect, b, v)
+	}
+
+	for _, v := range []string{"", "KB", "10XB", "1.2.3MB", "-5KB", "99999999999TiB"} {
+		require.Nil(t, f.ParseArgs([]string{"--size", v}, nil))
+		_, ok := f.ParamBytes("--size")
+		assert.False(t, ok, v)
+	}
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	_, ok := f.ParamBytes("--size")
+	assert.False(t, ok)
+}
+
+func TestParamInt64Uint(t *T) {
+	f := New("test-app", nil)
+	f.Add(Param{Name: "--id"})
+
+	require.Nil(t, f.ParseArgs([]string{"--id", "1234567890123456789"}, nil))
+	i, ok := f.ParamInt64("--id")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1234567890123456789), i)
+	u, ok := f.ParamUint("--id")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1234567890123456789), u)
+
+	require.Nil(t, f.ParseArgs([]string{"--id", "18446744073709551615"}, nil))
+	_, ok = f.ParamInt64("--id")
+	assert.False(t, ok)
+	u, ok = f.ParamUint("--id")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(18446744073709551615), u)
+
+	require.Nil(t, f.ParseArgs([]string{"--id", "-1"}, nil))
+	i, ok = f.ParamInt64("--id")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-1), i)
+	_, ok = f.ParamUint("--id")
+	assert.False(t, ok)
+
+	require.Nil(t, f.ParseArgs(nil, nil))
+	_, ok = f.ParamInt64("--id")
+	assert.False(t, ok)
+	_, ok = f.ParamUint("--id")
+	assert.False(t, ok)
+}
+
+func TestConfigSearchPaths(t *T) {
+	dir, err := os.MkdirTemp("", "lever-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.Setenv("LEVER_TEST_DIR", dir))
+	defer os.Unsetenv("LEVER_TEST_DIR")
+
+	require.Nil(t, os.WriteFile(dir+"/found.conf", []byte("foo: found\n"), 0600))
+	require.Nil(t, os.WriteFile(dir+"/later.conf", []byte("foo: later\n"), 0600))
+	require.Nil(t, os.WriteFile(dir+"/default.conf", []byte("foo: default\n"), 0600))
+
+	newLever := func(paths ...string) *Lever {
+		f := New("test-app", &Opts{
+			ConfigSearchPaths: paths,
+			DefaultConfigFile: dir + "/default.conf",
+		})
+		f.Add(Param{Name: "--foo"})
+		return f
+	}
+	check := func(f *Lever, args []string, expect string) {
+		require.Nil(t, f.ParseArgs(args, nil))
+		foo, _ := f.ParamStr("--foo")
+		assert.Equal(t, expect, foo)
+	}
+
+	f := newLever(dir+"/missing.conf", "$LEVER_TEST_DIR/found.conf", dir+"/later.conf")
+	check(f, nil, "found")
+	assert.Equal(t, dir+"/found.conf", f.ConfigFilePath())
+	check(f, []string{"--config", dir + "/later.conf"}, "later")
+
+	f = newLever(dir + "/missing.conf")
+	check(f, nil, "default")
+
+	// A path referencing an unset variable is skipped, rather than searched
+	// with the variable expanded to nothing
+	os.Unsetenv("LEVER_TEST_UNSET")
+	f = newLever(dir + "/$LEVER_TEST_UNSET/found.conf")
+	check(f, nil, "default")
+
+	f = New("test-app", &Opts{ConfigSearchPaths: []string{dir + "/missing.conf"}})
+	assert.Equal(t,
+		"no config file found, searched: "+dir+"/missing.conf",
+		f.ParseArgs(nil, nil).Error(),
+	)
+
+	// A directory exists but can't be read as a config file
+	f = newLever(dir, dir+"/found.conf")
+	assert.NotNil(t, f.ParseArgs(nil, nil))
+}
+
+func TestMultipleConfigFiles(t *T) {
+	base := testConfigFile(t, "foo: base\nbar: base\nbuz: a\nbuz: b\n")
+	defer os.Remove(base)
+	override := testConfigFile(t, "bar: override\nbuz: c\n")
+	defer os.Remove(override)
+
+	f := testLever(false)
+	require.Nil(t, f.ParseArgs(
+		[]string{"--config", base, "--config", override},
+		[]string{"TEST_APP_FOO=env"},
+	))
+	foo, _ := f.ParamStr("--foo")
+	bar, _ := f.ParamStr("--bar")
+	buz, _ := f.ParamStrs("--buz")
+	assert.Equal(t, "env", foo)
+	assert.Equal(t, "override", bar)
+	assert.Equal(t, []string{"c"}, buz)
+	assert.Equal(t, override, f.ConfigFilePath())
+
+	assert.NotNil(t, f.ParseArgs(
+		[]string{"--config", base, "--config", "/does/not/exist"}, nil,
+	))
+
+	f.o.AllowMissingConfigFile = true
+	require.Nil(t, f.ParseArgs(
+		[]string{"--config", base, "--config", "/does/not/exist"}, nil,
+	))
+	bar, _ = f.ParamStr("--bar")
+	assert.Equal(t, "base", bar)
+	assert.Equal(t, base, f.ConfigFilePath())
+}
+
+func TestConfigIncludes(t *T) {
+	dir, err := os.MkdirTemp("", "lever-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, os.Mkdir(dir+"/conf.d", 0700))
+	require.Nil(t, os.WriteFile(dir+"/main.conf", []byte(
+		"foo: main\nbuz: a\ninclude conf.d/extra.conf\nbuz: d\n",
+	), 0600))
+	require.Nil(t, os.WriteFile(dir+"/conf.d/extra.conf", []byte(
+		"bar: extra\nbuz: b\ninclude "+dir+"/conf.d/more.conf\n",
+	), 0600))
+	require.Nil(t, os.WriteFile(dir+"/conf.d/more.conf", []byte("buz: c\n"), 0600))
+
+	f := testLever(false)
+	args := []string{"--config", dir + "/main.conf"}
+	assert.NotNil(t, f.ParseArgs(args, nil))
+
+	f.o.AllowIncludes = true
+	require.Nil(t, f.ParseArgs(args, nil))
+	foo, _ := f.ParamStr("--foo")
+	bar, _ := f.ParamStr("--bar")
+	buz, _ := f.ParamStrs("--buz")
+	assert.Equal(t, "main", foo)
+	assert.Equal(t, "extra", bar)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, buz)
+
+	require.Nil(t, os.WriteFile(dir+"/cycle.conf", []byte("include cycle.conf\n"), 0600))
+	err = f.ParseArgs([]string{"--config", dir + "/cycle.conf"}, nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "there may be a cycle")
+}
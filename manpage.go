@@ -0,0 +1,80 @@
+package lever
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// roffEscaper escapes characters which have special meaning in roff text.
+// Dashes are escaped so they're rendered as minus signs, which matters for
+// option names which may be copied from the page
+var roffEscaper = strings.NewReplacer(
+	`\`, `\e`,
+	"-", `\-`,
+	"\n", " ",
+)
+
+// roffText returns the given string escaped so that it can be used as a line
+// of roff text, including not being mistaken for a request
+func roffText(s string) string {
+	s = roffEscaper.Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// roffBold returns the given string escaped and in bold
+func roffBold(s string) string {
+	return `\fB` + roffEscaper.Replace(s) + `\fR`
+}
+
+// ManPage returns the same information as Help(), but rendered as a roff
+// formatted man page in section 1, suitable for installing alongside the
+// application
+func (f *Lever) ManPage() string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	fmt.Fprintf(buf, ".TH %s 1\n", roffText(strings.ToUpper(f.appName)))
+
+	fmt.Fprintf(buf, ".SH NAME\n%s\n", roffText(f.appName))
+	fmt.Fprintf(buf, ".SH SYNOPSIS\n%s [OPTIONS]\n", roffBold(f.appName))
+
+	if f.o.HelpHeader != "" {
+		fmt.Fprintf(buf, ".SH DESCRIPTION\n%s\n", roffText(f.o.HelpHeader))
+	}
+
+	fmt.Fprintln(buf, ".SH OPTIONS")
+	for _, p := range f.sortedExpected() {
+		names := []string{roffBold(p.Name)}
+		for _, alias := range p.Aliases {
+			names = append(names, roffBold(alias))
+		}
+		fmt.Fprintf(buf, ".TP\n%s\n", strings.Join(names, ", "))
+
+		if p.Description != "" {
+			fmt.Fprintf(buf, "%s\n", roffText(p.Description))
+		}
+
+		var def string
+		if p.Flag {
+			def = fmt.Sprint(p.flagDefault())
+		} else if p.DefaultMulti != nil {
+			def = strings.Join(p.redactedMulti(p.DefaultMulti), ", ")
+		} else {
+			def = p.redacted(p.Default)
+		}
+		if def != "" {
+			if p.Description != "" {
+				fmt.Fprintln(buf, ".br")
+			}
+			fmt.Fprintf(buf, "Default: %s\n", roffText(def))
+		}
+	}
+
+	if f.o.HelpFooter != "" {
+		fmt.Fprintf(buf, ".SH NOTES\n%s\n", roffText(f.o.HelpFooter))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,27 @@
+package lever
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManPage(t *T) {
+	f := testLever(true)
+	f.Add(Param{
+		Name:        "--fancy",
+		Description: `.starts with a dot and has a \ backslash`,
+		Default:     "a-b",
+	})
+	man := f.ManPage()
+
+	assert.Contains(t, man, ".TH TEST\\-APP 1\n")
+	assert.Contains(t, man, ".SH NAME\ntest\\-app\n")
+	assert.Contains(t, man, ".SH OPTIONS\n")
+	assert.Contains(t, man, ".TP\n\\fB\\-\\-baz\\fR, \\fB\\-c\\fR\nwut\n.br\nDefault: wat\n")
+	assert.Contains(t, man, ".TP\n\\fB\\-\\-buz\\fR, \\fB\\-d\\fR\nwut\n.br\nDefault: a, b, c\n")
+	assert.Contains(t, man, ".TP\n\\fB\\-\\-foo\\fR\n.TP\n")
+	assert.Contains(t, man,
+		".TP\n\\fB\\-\\-fancy\\fR\n\\&.starts with a dot and has a \\e backslash\n.br\nDefault: a\\-b\n")
+	assert.NotContains(t, man, ".SH DESCRIPTION")
+}
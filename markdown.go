@@ -0,0 +1,89 @@
+package lever
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// markdownEscaper escapes characters which have special meaning in markdown
+// text, including the pipe which would otherwise end a table cell
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+	">", `\>`,
+	"|", `\|`,
+	"\n", " ",
+)
+
+// markdownCode returns the given string as an inline code span suitable for use
+// in a table cell, or an empty string if the given string is empty
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.Replace(s, "|", `\|`, -1) + "`"
+}
+
+// paramType returns a short description of the kind of value the param takes
+func (p *Param) paramType() string {
+	if p.Flag {
+		return "flag"
+	} else if p.DefaultMulti != nil {
+		return "multi"
+	}
+	return "value"
+}
+
+// HelpMarkdown returns the same information as Help(), but rendered as a
+// markdown document with a table of all params, suitable for including in
+// generated documentation
+func (f *Lever) HelpMarkdown() string {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	fmt.Fprintf(buf, "# %s\n\n", markdownEscaper.Replace(f.appName))
+
+	if f.o.HelpHeader != "" {
+		fmt.Fprintf(buf, "%s\n\n", markdownEscaper.Replace(f.o.HelpHeader))
+	}
+
+	fmt.Fprintln(buf, "| Name | Aliases | Type | Default | Description |")
+	fmt.Fprintln(buf, "| --- | --- | --- | --- | --- |")
+	for _, p := range f.sortedExpected() {
+		aliases := make([]string, len(p.Aliases))
+		for i := range p.Aliases {
+			aliases[i] = markdownCode(p.Aliases[i])
+		}
+
+		var def string
+		if p.Flag {
+			def = markdownCode(fmt.Sprint(p.flagDefault()))
+		} else if p.DefaultMulti != nil {
+			defs := make([]string, len(p.DefaultMulti))
+			for i := range p.DefaultMulti {
+				defs[i] = markdownCode(p.redacted(p.DefaultMulti[i]))
+			}
+			def = strings.Join(defs, ", ")
+		} else {
+			def = markdownCode(p.redacted(p.Default))
+		}
+
+		fmt.Fprintf(buf, "| %s | %s | %s | %s | %s |\n",
+			markdownCode(p.Name),
+			strings.Join(aliases, ", "),
+			p.paramType(),
+			def,
+			markdownEscaper.Replace(p.Description),
+		)
+	}
+
+	if f.o.HelpFooter != "" {
+		fmt.Fprintf(buf, "\n%s\n", markdownEscaper.Replace(f.o.HelpFooter))
+	}
+
+	return buf.String()
+}
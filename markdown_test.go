@@ -0,0 +1,30 @@
+package lever
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelpMarkdown(t *T) {
+	f := testLever(true)
+	f.Add(Param{
+		Name:        "--fancy",
+		Description: "Uses *stars*, _underscores_ and a | pipe",
+		Default:     "a|b",
+	})
+
+	assert.Equal(t, "# test-app\n\n"+
+		"| Name | Aliases | Type | Default | Description |\n"+
+		"| --- | --- | --- | --- | --- |\n"+
+		"| `--bar` | `-b` | value |  | wut |\n"+
+		"| `--baz` | `-c` | value | `wat` | wut |\n"+
+		"| `--buz` | `-d` | multi | `a`, `b`, `c` | wut |\n"+
+		"| `--byz` |  | multi |  |  |\n"+
+		"| `--fancy` |  | value | `a\\|b` | Uses \\*stars\\*, \\_underscores\\_ and a \\| pipe |\n"+
+		"| `--flag1` |  | flag | `false` |  |\n"+
+		"| `--flag2` |  | flag | `false` |  |\n"+
+		"| `--foo` |  | value |  |  |\n"+
+		"| `--help` | `-help`, `-h` | flag | `false` | Print this help message |\n",
+		f.HelpMarkdown())
+}
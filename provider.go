@@ -0,0 +1,80 @@
+package lever
+
+import "fmt"
+
+// Provider is an external source of param values, for example a key/value
+// store like Consul or etcd, see Opts.Providers
+type Provider interface {
+
+	// Lookup returns the values for the given key, which is a param's name as
+	// it would appear in the config file, for example "foo-bar" for
+	// "--foo-bar". False is returned if the provider has no values for it
+	Lookup(key string) ([]string, bool, error)
+}
+
+// readProviders looks up each of the given params in the Providers given in
+// Opts, using the values from the first provider which has any
+func (f *Lever) readProviders(ps params) (map[string][]string, error) {
+	found := map[string][]string{}
+	if len(f.o.Providers) == 0 {
+		return found, nil
+	}
+
+	var errs MultiError
+	for _, p := range ps {
+		if f.isExitFlag(p) {
+			continue
+		}
+		for _, pr := range f.o.Providers {
+			vals, ok, err := pr.Lookup(p.configName())
+			if err != nil {
+				errs = append(errs, &ParamError{
+					Param:   p.Name,
+					Source:  SourceProvider,
+					Message: fmt.Sprintf("error looking up %q: %s", p.configName(), err),
+				})
+				break
+			} else if ok {
+				found[p.Name] = vals
+				break
+			}
+		}
+	}
+	return found, errs.err()
+}
+
+// sourceOrder is the normal precedence of the sources which values are read
+// from, highest first
+var sourceOrder = map[Source]int{
+	SourceCLI:     0,
+	SourceEnv:     1,
+	SourceConfig:  2,
+	SourceSeed:    3,
+	SourceDefault: 4,
+}
+
+// withProvided returns the given sources, which must be in sourceOrder, with
+// the values from the Providers given in Opts inserted just above
+// ProviderPrecedence. This is the same place whether or not that source is
+// among the given ones, for example when the config file is disallowed
+func (f *Lever) withProvided(
+	srcs []sourceValues, provided map[string][]string,
+) []sourceValues {
+	precedence := f.o.ProviderPrecedence
+	if precedence == "" {
+		precedence = SourceConfig
+	}
+
+	i := len(srcs)
+	for j := range srcs {
+		if sourceOrder[srcs[j].source] >= sourceOrder[precedence] {
+			i = j
+			break
+		}
+	}
+
+	out := make([]sourceValues, 0, len(srcs)+1)
+	out = append(out, srcs[:i]...)
+	out = append(out, sourceValues{SourceProvider, provided})
+	return append(out, srcs[i:]...)
+}
@@ -0,0 +1,144 @@
+package lever
+
+import (
+	"errors"
+	"os"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mapProvider map[string][]string
+
+// countingProvider counts how many times each key is looked up
+type countingProvider struct {
+	mapProvider
+	lookups map[string]int
+}
+
+func (cp *countingProvider) Lookup(key string) ([]string, bool, error) {
+	cp.lookups[key]++
+	return cp.mapProvider.Lookup(key)
+}
+
+func (mp mapProvider) Lookup(key string) ([]string, bool, error) {
+	if key == "broken" {
+		return nil, false, errors.New("connection refused")
+	}
+	vals, ok := mp[key]
+	return vals, ok, nil
+}
+
+func TestProviders(t *T) {
+	path := testConfigFile(t, "foo: config\nbar: config\n")
+	defer os.Remove(path)
+
+	newLever := func(precedence Source) *Lever {
+		f := New("test-app", &Opts{
+			Providers: []Provider{
+				mapProvider{"foo": {"first"}},
+				mapProvider{"foo": {"second"}, "bar": {"second"}, "buz": {"a", "b"}},
+			},
+			ProviderPrecedence: precedence,
+		})
+		f.Add(Param{Name: "--foo"})
+		f.Add(Param{Name: "--bar"})
+		f.Add(Param{Name: "--baz", Default: "default"})
+		f.Add(Param{Name: "--buz"})
+		return f
+	}
+
+	f := newLever("")
+	require.Nil(t, f.ParseArgs(
+		[]string{"--config", path}, []string{"TEST_APP_BAR=env"},
+	))
+	for name, expected := range map[string][]string{
+		"--foo": {"first"},
+		"--bar": {"env"},
+		"--baz": {"default"},
+		"--buz": {"a", "b"},
+	} {
+		vals, _ := f.ParamStrs(name)
+		assert.Equal(t, expected, vals, name)
+	}
+	_, src, _ := f.Resolve("--foo")
+	assert.Equal(t, SourceProvider, src)
+
+	f = newLever(SourceEnv)
+	require.Nil(t, f.ParseArgs(
+		[]string{"--foo", "cli"}, []string{"TEST_APP_BAR=env"},
+	))
+	foo, _ := f.ParamStr("--foo")
+	bar, _ := f.ParamStr("--bar")
+	assert.Equal(t, "cli", foo)
+	assert.Equal(t, "second", bar)
+
+	f = newLever(SourceDefault)
+	require.Nil(t, f.ParseArgs([]string{"--config", path}, nil))
+	foo, _ = f.ParamStr("--foo")
+	assert.Equal(t, "config", foo)
+
+	f = newLever("")
+	f.Add(Param{Name: "--broken"})
+	assert.NotNil(t, f.ParseArgs(nil, nil))
+}
+
+func TestProvidersSeed(t *T) {
+	path := testConfigFile(t, "")
+	defer os.Remove(path)
+
+	for _, disallowConfig := range []bool{false, true} {
+		f := New("test-app", &Opts{
+			Providers:          []Provider{mapProvider{"foo": {"prov"}}},
+			DisallowConfigFile: disallowConfig,
+		})
+		f.Add(Param{Name: "--foo"})
+		f.Seed(map[string][]string{"--foo": {"seed"}})
+
+		var args []string
+		if !disallowConfig {
+			args = []string{"--config", path}
+		}
+		require.Nil(t, f.ParseArgs(args, nil))
+		foo, _ := f.ParamStr("--foo")
+		assert.Equal(t, "prov", foo, "disallowConfig:%v", disallowConfig)
+	}
+}
+
+func TestProvidersResolve(t *T) {
+	cp := &countingProvider{
+		mapProvider: mapProvider{"foo": {"a"}, "late": {"b"}},
+		lookups:     map[string]int{},
+	}
+	f := New("test-app", &Opts{
+		Providers:          []Provider{cp},
+		AllowAddAfterParse: true,
+		DisallowConfigFile: true,
+	})
+	f.Add(Param{Name: "--foo"})
+	f.Add(Param{Name: "--bar"})
+	require.Nil(t, f.ParseArgs(nil, nil))
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 1}, cp.lookups)
+
+	// Params which were looked up during the parse aren't looked up again
+	vals, src, ok := f.Resolve("--foo")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a"}, vals)
+	assert.Equal(t, SourceProvider, src)
+	f.Resolve("--bar")
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 1}, cp.lookups)
+
+	// A param added afterwards is looked up once
+	f.Add(Param{Name: "--late"})
+	f.Add(Param{Name: "--broken"})
+	for i := 0; i < 2; i++ {
+		vals, _, _ = f.Resolve("--late")
+		assert.Equal(t, []string{"b"}, vals)
+		f.Resolve("--broken")
+	}
+	assert.Equal(t, 1, cp.lookups["late"])
+	assert.Equal(t, 1, cp.lookups["broken"])
+	require.Len(t, f.Warnings(), 1)
+	assert.Contains(t, f.Warnings()[0], "connection refused")
+}
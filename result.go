@@ -0,0 +1,131 @@
+package lever
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Result holds the values resolved by a single parse, independent of the Lever
+// which produced it. It can be passed around to the parts of an application
+// which need to read configuration without giving them access to the param
+// definitions. Its methods behave exactly like their Param* counterparts on
+// Lever.
+type Result struct {
+	l *Lever
+}
+
+// ParseResult is like ParseArgs, but on success also returns a Result holding
+// the resolved values. The Result is not affected by any later parses of the
+// Lever.
+func (f *Lever) ParseResult(args, environ []string) (*Result, error) {
+	if err := f.ParseArgs(args, environ); err != nil {
+		return nil, err
+	}
+	snapshot := *f
+	return &Result{l: &snapshot}, nil
+}
+
+// Str is like ParamStr
+func (r *Result) Str(name string) (string, bool) {
+	return r.l.ParamStr(name)
+}
+
+// Strs is like ParamStrs
+func (r *Result) Strs(name string) ([]string, bool) {
+	return r.l.ParamStrs(name)
+}
+
+// Int is like ParamInt
+func (r *Result) Int(name string) (int, bool) {
+	return r.l.ParamInt(name)
+}
+
+// Ints is like ParamInts
+func (r *Result) Ints(name string) ([]int, bool) {
+	return r.l.ParamInts(name)
+}
+
+// Int64 is like ParamInt64
+func (r *Result) Int64(name string) (int64, bool) {
+	return r.l.ParamInt64(name)
+}
+
+// Bytes is like ParamBytes
+func (r *Result) Bytes(name string) (int64, bool) {
+	return r.l.ParamBytes(name)
+}
+
+// Uint is like ParamUint
+func (r *Result) Uint(name string) (uint64, bool) {
+	return r.l.ParamUint(name)
+}
+
+// Float is like ParamFloat
+func (r *Result) Float(name string) (float64, bool) {
+	return r.l.ParamFloat(name)
+}
+
+// Floats is like ParamFloats
+func (r *Result) Floats(name string) ([]float64, bool) {
+	return r.l.ParamFloats(name)
+}
+
+// Duration is like ParamDuration
+func (r *Result) Duration(name string) (time.Duration, bool) {
+	return r.l.ParamDuration(name)
+}
+
+// Durations is like ParamDurations
+func (r *Result) Durations(name string) ([]time.Duration, bool) {
+	return r.l.ParamDurations(name)
+}
+
+// Bool is like ParamBool
+func (r *Result) Bool(name string) (bool, bool) {
+	return r.l.ParamBool(name)
+}
+
+// HostPort is like ParamHostPort
+func (r *Result) HostPort(name string) (string, int, bool) {
+	return r.l.ParamHostPort(name)
+}
+
+// RawJSON is like ParamRawJSON
+func (r *Result) RawJSON(name string) (json.RawMessage, bool) {
+	return r.l.ParamRawJSON(name)
+}
+
+// NestedMap is like ParamNestedMap
+func (r *Result) NestedMap(prefix string) map[string]map[string]string {
+	return r.l.ParamNestedMap(prefix)
+}
+
+// IndexedMaps is like ParamIndexedMaps
+func (r *Result) IndexedMaps(prefix string) []map[string]string {
+	return r.l.ParamIndexedMaps(prefix)
+}
+
+// Flag is like ParamFlag
+func (r *Result) Flag(name string) bool {
+	return r.l.ParamFlag(name)
+}
+
+// Source is like Lever's Source
+func (r *Result) Source(name string) (Source, bool) {
+	return r.l.Source(name)
+}
+
+// Rest is like ParamRest
+func (r *Result) Rest() []string {
+	return r.l.ParamRest()
+}
+
+// RestUnknown is like ParamRestUnknown
+func (r *Result) RestUnknown() []string {
+	return r.l.ParamRestUnknown()
+}
+
+// RestVerbatim is like ParamRestVerbatim
+func (r *Result) RestVerbatim() []string {
+	return r.l.ParamRestVerbatim()
+}
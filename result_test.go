@@ -0,0 +1,52 @@
+package lever
+
+import (
+	"io"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResult(t *T) {
+	f := testLever(false)
+	f.Add(Param{Name: "--num", Default: "5"})
+
+	r, err := f.ParseResult(
+		[]string{"--bar", "bar", "--flag1", "--buz", "1", "--buz", "2", "extra"},
+		[]string{"TEST_APP_FOO=foo", "TEST_APP_NUM=10"},
+	)
+	require.Nil(t, err)
+
+	foo, ok := r.Str("--foo")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", foo)
+
+	num, ok := r.Int("--num")
+	assert.True(t, ok)
+	assert.Equal(t, 10, num)
+
+	buz, ok := r.Ints("--buz")
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 2}, buz)
+
+	assert.True(t, r.Flag("--flag1"))
+	assert.False(t, r.Flag("--flag2"))
+	assert.Equal(t, []string{"extra"}, r.Rest())
+
+	src, ok := r.Source("--foo")
+	assert.True(t, ok)
+	assert.Equal(t, SourceEnv, src)
+
+	// Parsing again must not change the values held by the first Result
+	r2, err := f.ParseResult([]string{"--bar", "other"}, nil)
+	require.Nil(t, err)
+	bar, _ := r.Str("--bar")
+	assert.Equal(t, "bar", bar)
+	bar, _ = r2.Str("--bar")
+	assert.Equal(t, "other", bar)
+
+	f.o.Output = io.Discard
+	_, err = f.ParseResult([]string{"--help"}, nil)
+	assert.Equal(t, ErrHelp, err)
+}
@@ -0,0 +1,64 @@
+package lever
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// snapshot is the serialized form of a Lever's parsed state
+type snapshot struct {
+	Params    []string            `json:"params"`
+	Found     map[string][]string `json:"found"`
+	Sources   map[string]Source   `json:"sources"`
+	Remaining []string            `json:"remaining"`
+	RestSplit int                 `json:"rest_split"`
+}
+
+// Snapshot serializes the values resolved by the most recent parse, so that
+// they can later be loaded into a Lever with the same params using Restore
+// without reading any sources again. Parse must have been called already
+func (f *Lever) Snapshot() ([]byte, error) {
+	if !f.frozen {
+		return nil, fmt.Errorf("lever has not been parsed")
+	}
+	return json.Marshal(snapshot{
+		Params:    f.ParamNames(true),
+		Found:     f.found,
+		Sources:   f.foundSource,
+		Remaining: f.remaining,
+		RestSplit: f.restSplit,
+	})
+}
+
+// Restore loads values serialized by Snapshot into the Lever, as if it had been
+// parsed. The Lever must have exactly the same params as the one the snapshot
+// was taken from, though they are matched only by Name
+func (f *Lever) Restore(b []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("error decoding snapshot: %s", err)
+	}
+
+	if names := f.ParamNames(true); !equalStrs(names, s.Params) {
+		return fmt.Errorf(
+			"snapshot params don't match: expected %s, snapshot has %s",
+			strings.Join(names, ", "), strings.Join(s.Params, ", "),
+		)
+	}
+
+	if s.RestSplit < 0 || s.RestSplit > len(s.Remaining) {
+		return fmt.Errorf("snapshot has invalid rest_split %d", s.RestSplit)
+	}
+
+	if f.found != nil {
+		f.previous = f.found
+	}
+	f.found = s.Found
+	f.foundSource = s.Sources
+	f.remaining = s.Remaining
+	f.restSplit = s.RestSplit
+	f.provided, f.providerChecked = nil, nil
+	f.frozen = true
+	return nil
+}
@@ -0,0 +1,36 @@
+package lever
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *T) {
+	f := testLever(false)
+	require.Nil(t, f.ParseArgs(
+		[]string{"--bar", "bar", "--buz", "1", "--buz", "2", "extra"},
+		[]string{"TEST_APP_FOO=foo"},
+	))
+	b, err := f.Snapshot()
+	require.Nil(t, err)
+
+	f2 := testLever(false)
+	require.Nil(t, f2.Restore(b))
+	for _, name := range []string{"--foo", "--bar", "--buz", "--flag1"} {
+		vs, ok := f.ParamStrs(name)
+		vs2, ok2 := f2.ParamStrs(name)
+		assert.Equal(t, vs, vs2, name)
+		assert.Equal(t, ok, ok2, name)
+	}
+	assert.Equal(t, []string{"extra"}, f2.ParamRest())
+	assert.Equal(t, f.foundSource, f2.foundSource)
+
+	f3 := testLever(false)
+	f3.Add(Param{Name: "--other"})
+	assert.NotNil(t, f3.Restore(b))
+
+	_, err = testLever(false).Snapshot()
+	assert.NotNil(t, err)
+}